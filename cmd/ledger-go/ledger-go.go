@@ -21,12 +21,25 @@ func fileExists(filename string) (bool, error) {
 	return true, err
 }
 
+// fileList is a flag.Value collecting repeated -file flags.
+type fileList []string
+
+func (l *fileList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *fileList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type flags struct {
-	file       string
+	files      fileList
 	priceDB    string
 	noMetadata string
 	strict     bool
 	noPager    bool
+	precision  int
 
 	// extensions
 	addMissingHashes bool
@@ -34,13 +47,15 @@ type flags struct {
 
 func defineFlags() *flags {
 	var f flags
-	flag.StringVar(&f.file, "file", "", "Read journal data from FILE.")
+	flag.Var(&f.files, "file", "Read journal data from FILE (can be repeated).")
 	flag.StringVar(&f.priceDB, "price-db", "", "Read price DB from FILE.")
 	flag.StringVar(&f.noMetadata, "no-metadata", "no-metadata.conf", "Read no metadata configruation from FILE.")
 	flag.BoolVar(&f.strict, "strict", false,
 		"Accounts or commodities  not  previously  declared  will cause warnings.")
 	flag.BoolVar(&f.noPager, "no-pager", false,
 		"Disables the pager on TTY output.")
+	flag.IntVar(&f.precision, "precision", ledger.DefaultPrecision,
+		"Print amounts with PRECISION decimal places.")
 
 	// extensions
 	flag.BoolVar(&f.addMissingHashes, "add-missing-hashes", false,
@@ -71,11 +86,40 @@ func parseLedgerRC(f *flags) error {
 	if err := flag.CommandLine.Parse(sb); err != nil {
 		return err
 	}
-	f.file = strings.Replace(f.file, "~", homeDir, 1)
+	for i := range f.files {
+		f.files[i] = strings.Replace(f.files[i], "~", homeDir, 1)
+	}
 	f.priceDB = strings.Replace(f.priceDB, "~", homeDir, 1)
 	return nil
 }
 
+// envFlags maps environment variables to the flags they set.
+var envFlags = map[string]string{
+	"LEDGER_FILE":        "file",
+	"LEDGER_PRICE_DB":    "price-db",
+	"LEDGER_NO_METADATA": "no-metadata",
+	"LEDGER_STRICT":      "strict",
+	"LEDGER_PRECISION":   "precision",
+}
+
+// parseEnv sets flags from environment variables, overriding .ledgerrc.
+func parseEnv(f *flags) error {
+	for env, name := range envFlags {
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if name == "file" {
+			// replace files from .ledgerrc instead of adding to them
+			f.files = nil
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s", env, err)
+		}
+	}
+	return nil
+}
+
 func fatal(err error) {
 	fmt.Fprintf(os.Stderr, "%s: error: %s\n", os.Args[0], err)
 	os.Exit(1)
@@ -87,11 +131,54 @@ func main() {
 	if err := parseLedgerRC(f); err != nil {
 		fatal(err)
 	}
+	// parse flags from environment
+	if err := parseEnv(f); err != nil {
+		fatal(err)
+	}
+	// files given on the command line replace the ones from .ledgerrc or
+	// environment
+	rcFiles := f.files
+	f.files = nil
 	// parse command line flags
 	flag.Parse()
-	l, err := ledger.New(f.file, f.strict, f.addMissingHashes, f.noMetadata)
+	f.files = append(f.files, flag.Args()...)
+	if len(f.files) == 0 {
+		f.files = rcFiles
+	}
+	if len(f.files) == 0 {
+		f.files = fileList{""}
+	}
+	cfg := ledger.Config{
+		Strict:             f.strict,
+		AddMissingHashes:   f.addMissingHashes,
+		NoMetadataFilename: f.noMetadata,
+		Precision:          &f.precision,
+		// assertions and metadata can depend on entries in other files
+		DeferAssertions: len(f.files) > 1,
+		DeferMetadata:   len(f.files) > 1,
+	}
+	l, err := ledger.NewWithConfig(f.files[0], cfg)
 	if err != nil {
 		fatal(err)
 	}
+	for _, file := range f.files[1:] {
+		other, err := ledger.NewWithConfig(file, cfg)
+		if err != nil {
+			fatal(err)
+		}
+		if err := l.Merge(other); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.DeferAssertions {
+		if err := l.ValidateAssertions(); err != nil {
+			fatal(err)
+		}
+	}
+	if cfg.DeferMetadata {
+		if err := l.ValidateMetadata(); err != nil {
+			fatal(err)
+		}
+	}
 	l.Print()
 }
@@ -0,0 +1,265 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// balancingAmounts returns the amounts per commodity an elided account has
+// to receive to balance the entry.
+func (e *LedgerEntry) balancingAmounts() map[string]float64 {
+	amounts := make(map[string]float64)
+	for i := range e.Accounts {
+		a := &e.Accounts[i]
+		if a.Commodity == "" {
+			continue
+		}
+		amount, commodity := a.balanceAmount()
+		amounts[commodity] -= amount
+	}
+	return amounts
+}
+
+// sortedCommodities returns the commodities of amounts in sorted order.
+func sortedCommodities(amounts map[string]float64) []string {
+	var commodities []string
+	for commodity := range amounts {
+		commodities = append(commodities, commodity)
+	}
+	sort.Strings(commodities)
+	return commodities
+}
+
+// postings calls fn for every posting amount of the entry. Elided amounts
+// are honored: if the entry's elided account could not be resolved to a
+// single commodity, it receives the balancing amount for every commodity of
+// the entry.
+func (e *LedgerEntry) postings(fn func(account, commodity string, amount float64)) {
+	elided := ""
+	for i := range e.Accounts {
+		a := &e.Accounts[i]
+		if a.Commodity == "" {
+			elided = a.Name
+			continue
+		}
+		fn(a.Name, a.Commodity, a.Amount)
+	}
+	if elided != "" {
+		amounts := e.balancingAmounts()
+		for _, commodity := range sortedCommodities(amounts) {
+			fn(elided, commodity, amounts[commodity])
+		}
+	}
+}
+
+// Balances returns the summed amounts per account and commodity over all
+// report entries. Elided amounts are included, also for accounts which only
+// ever appear elided. Parent accounts are not rolled up, see PrintBalances.
+func (l *Ledger) Balances() map[string]map[string]float64 {
+	balances := make(map[string]map[string]float64)
+	entries := l.reportEntries()
+	for i := range entries {
+		entries[i].postings(func(account, commodity string, amount float64) {
+			if balances[account] == nil {
+				balances[account] = make(map[string]float64)
+			}
+			balances[account][commodity] += amount
+		})
+	}
+	return balances
+}
+
+// ValidateAssertions checks the balance assertions of all entries. An
+// assertion holds the expected balance of the posting's account (without
+// subaccounts) in the asserted commodity after all postings of its entry
+// have been applied. Entries are processed in ledger order. Errors for
+// entries from included or merged files are prefixed with the filename.
+//
+// Assertions are checked when a ledger is parsed, unless
+// Config.DeferAssertions is set.
+func (l *Ledger) ValidateAssertions() error {
+	running := make(map[string]map[string]float64)
+	for i := range l.Entries {
+		e := &l.Entries[i]
+		e.postings(func(account, commodity string, amount float64) {
+			if running[account] == nil {
+				running[account] = make(map[string]float64)
+			}
+			running[account][commodity] += amount
+		})
+		for j, a := range e.Accounts {
+			if a.AssertionCommodity == "" {
+				continue
+			}
+			balance := running[a.Name][a.AssertionCommodity]
+			if math.Abs(balance-a.AssertionAmount) > balanceEpsilon {
+				// postings directly follow the entry line
+				err := fmt.Errorf("ledger: line %d: balance assertion failed for %s: expected %s %s, got %s %s",
+					e.LineNumber+1+j, a.Name,
+					formatAmount(a.AssertionAmount, l.precision(a.AssertionCommodity)), a.AssertionCommodity,
+					formatAmount(balance, l.precision(a.AssertionCommodity)), a.AssertionCommodity)
+				if e.file != "" {
+					return fmt.Errorf("%s: %w", e.file, err)
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NegativeAssetBalances returns the asset accounts whose running balance
+// in a commodity dropped below zero in entries dated up to asOf. For every
+// such account and commodity the lowest balance reached is returned. A
+// negative asset balance usually indicates a missing entry or a wrong sign.
+func (l *Ledger) NegativeAssetBalances(asOf time.Time) map[string]map[string]float64 {
+	running := make(map[string]map[string]float64)
+	negative := make(map[string]map[string]float64)
+	entries := l.reportEntries()
+	for i := range entries {
+		e := &entries[i]
+		if e.currentDate().After(asOf) {
+			break
+		}
+		// apply the net change of the entry, postings within an entry are
+		// not ordered
+		changes := make(map[string]map[string]float64)
+		e.postings(func(account, commodity string, amount float64) {
+			if !strings.HasPrefix(account, "Assets:") {
+				return
+			}
+			if changes[account] == nil {
+				changes[account] = make(map[string]float64)
+			}
+			changes[account][commodity] += amount
+		})
+		for account, amounts := range changes {
+			if running[account] == nil {
+				running[account] = make(map[string]float64)
+			}
+			for commodity, amount := range amounts {
+				running[account][commodity] += amount
+				balance := running[account][commodity]
+				if balance >= -balanceEpsilon {
+					continue
+				}
+				if negative[account] == nil {
+					negative[account] = make(map[string]float64)
+				}
+				if lowest, ok := negative[account][commodity]; !ok || balance < lowest {
+					negative[account][commodity] = balance
+				}
+			}
+		}
+	}
+	return negative
+}
+
+// balanceNode is a single account in the balance tree.
+type balanceNode struct {
+	name     string // last component of the account name
+	amounts  map[string]float64
+	children map[string]*balanceNode
+}
+
+func newBalanceNode(name string) *balanceNode {
+	return &balanceNode{
+		name:     name,
+		amounts:  make(map[string]float64),
+		children: make(map[string]*balanceNode),
+	}
+}
+
+// balanceTree returns the root of the account hierarchy, where every node
+// holds the totals of its own postings and all of its subaccounts.
+func (l *Ledger) balanceTree() *balanceNode {
+	root := newBalanceNode("")
+	for account, amounts := range l.Balances() {
+		node := root
+		for _, name := range strings.Split(account, ":") {
+			child, ok := node.children[name]
+			if !ok {
+				child = newBalanceNode(name)
+				node.children[name] = child
+			}
+			node = child
+			for commodity, amount := range amounts {
+				node.amounts[commodity] += amount
+			}
+		}
+		for commodity, amount := range amounts {
+			root.amounts[commodity] += amount
+		}
+	}
+	return root
+}
+
+// printBalanceAmounts prints the amounts of a single balance line, one
+// commodity per line, with label in the account column of the first line.
+// Amounts within balanceEpsilon of zero are printed as zero.
+func printBalanceAmounts(
+	w io.Writer,
+	label string,
+	amounts map[string]float64,
+	precision func(commodity string) int,
+) {
+	var commodities []string
+	for c := range amounts {
+		commodities = append(commodities, c)
+	}
+	sort.Strings(commodities)
+	if len(commodities) == 0 {
+		fmt.Fprintf(w, "%s\n", label)
+		return
+	}
+	for i, c := range commodities {
+		if i > 0 {
+			label = ""
+		}
+		padding := AccountWidth - len(label)
+		if padding < 1 {
+			padding = 1
+		}
+		amount := amounts[c]
+		if amount > -balanceEpsilon && amount < balanceEpsilon {
+			amount = 0
+		}
+		fmt.Fprintf(w, "%s%s%14s %s\n", label, strings.Repeat(" ", padding),
+			formatAmount(amount, precision(c)), c)
+	}
+}
+
+func (n *balanceNode) print(
+	w io.Writer,
+	level, depth int,
+	precision func(commodity string) int,
+) {
+	var names []string
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := n.children[name]
+		printBalanceAmounts(w, strings.Repeat("  ", level)+child.name,
+			child.amounts, precision)
+		if depth <= 0 || level+1 < depth {
+			child.print(w, level+1, depth, precision)
+		}
+	}
+}
+
+// PrintBalances prints the balance of every account to w as a tree, where
+// parent accounts show the totals of their subaccounts. The tree is
+// collapsed to depth levels, a depth <= 0 prints all levels. The report
+// ends with the grand total per commodity.
+func (l *Ledger) PrintBalances(w io.Writer, depth int) {
+	root := l.balanceTree()
+	root.print(w, 0, depth, l.precision)
+	fmt.Fprintln(w, strings.Repeat("-", AccountWidth+18))
+	printBalanceAmounts(w, "Total", root.amounts, l.precision)
+}
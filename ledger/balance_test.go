@@ -0,0 +1,368 @@
+package ledger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrintBalances(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+commodity USD
+
+account Assets:Bank:Checking
+account Assets:Cash
+account Equity:Opening
+account Expenses:Food
+
+2024/01/01 Opening
+  Assets:Bank:Checking  1000,00 EUR
+  Assets:Cash  50,00 USD
+  Equity:Opening
+
+2024/01/02 Grocery store
+  Expenses:Food  25,50 EUR
+  Assets:Bank:Checking
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	t.Run("balances honor elided amounts", func(t *testing.T) {
+		b := l.Balances()
+		if b["Assets:Bank:Checking"]["EUR"] != 974.5 {
+			t.Errorf("Assets:Bank:Checking = %v, want 974.5", b["Assets:Bank:Checking"]["EUR"])
+		}
+		if b["Equity:Opening"]["EUR"] != -1000 || b["Equity:Opening"]["USD"] != -50 {
+			t.Errorf("Equity:Opening = %v, want -1000 EUR and -50 USD", b["Equity:Opening"])
+		}
+	})
+
+	t.Run("all levels", func(t *testing.T) {
+		var buf bytes.Buffer
+		l.PrintBalances(&buf, 0)
+		want := "" +
+			"Assets                                                974,50 EUR\n" +
+			"                                                       50,00 USD\n" +
+			"  Bank                                                974,50 EUR\n" +
+			"    Checking                                          974,50 EUR\n" +
+			"  Cash                                                 50,00 USD\n" +
+			"Equity                                              -1000,00 EUR\n" +
+			"                                                      -50,00 USD\n" +
+			"  Opening                                           -1000,00 EUR\n" +
+			"                                                      -50,00 USD\n" +
+			"Expenses                                               25,50 EUR\n" +
+			"  Food                                                 25,50 EUR\n" +
+			"----------------------------------------------------------------\n" +
+			"Total                                                   0,00 EUR\n" +
+			"                                                        0,00 USD\n"
+		if buf.String() != want {
+			t.Errorf("PrintBalances() =\n%s\nwant:\n%s", buf.String(), want)
+		}
+	})
+
+	t.Run("depth limited", func(t *testing.T) {
+		var buf bytes.Buffer
+		l.PrintBalances(&buf, 1)
+		want := "" +
+			"Assets                                                974,50 EUR\n" +
+			"                                                       50,00 USD\n" +
+			"Equity                                              -1000,00 EUR\n" +
+			"                                                      -50,00 USD\n" +
+			"Expenses                                               25,50 EUR\n" +
+			"----------------------------------------------------------------\n" +
+			"Total                                                   0,00 EUR\n" +
+			"                                                        0,00 USD\n"
+		if buf.String() != want {
+			t.Errorf("PrintBalances() =\n%s\nwant:\n%s", buf.String(), want)
+		}
+	})
+}
+
+func TestBalanceAssertions(t *testing.T) {
+	opening := `2024/01/01 Opening balance
+  Assets:Bank  1000,00 EUR
+  Equity:Opening
+
+`
+	tests := []struct {
+		name        string
+		entries     string
+		errContains string
+	}{
+		{
+			name: "assertion holds",
+			entries: `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank  -50,00 EUR = 950,00 EUR
+`,
+		},
+		{
+			name: "assertion on elided posting",
+			entries: `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank = 950,00 EUR
+`,
+		},
+		{
+			name: "assertion counts earlier entries",
+			entries: `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/06 Store
+  Expenses:Food  25,00 EUR
+  Assets:Bank  -25,00 EUR = 925,00 EUR
+`,
+		},
+		{
+			name: "assertion fails",
+			entries: `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank  -50,00 EUR = 900,00 EUR
+`,
+			errContains: "line 7: balance assertion failed for Assets:Bank: expected 900,00 EUR, got 950,00 EUR",
+		},
+		{
+			name: "assertion in other commodity",
+			entries: `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank  -50,00 EUR = 10,00 USD
+`,
+			errContains: "expected 10,00 USD, got 0,00 USD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ledgerFile := filepath.Join(dir, "test.ledger")
+			if err := os.WriteFile(ledgerFile, []byte(opening+tt.entries), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
+			l, err := New(ledgerFile, false, false, "")
+			if tt.errContains != "" {
+				if err == nil || !contains(err.Error(), tt.errContains) {
+					t.Errorf("New() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			// assertions survive printing
+			var buf bytes.Buffer
+			if _, err := l.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo() error: %v", err)
+			}
+			if !contains(buf.String(), " = ") {
+				t.Errorf("WriteTo() lost the assertion:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestBalanceAssertionsAcrossFiles(t *testing.T) {
+	t.Run("included file", func(t *testing.T) {
+		dir := t.TempDir()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+		subFile := filepath.Join(dir, "sub.ledger")
+		main := `2024/01/01 Opening balance
+  Assets:Bank  1000,00 EUR
+  Equity:Opening
+
+include sub.ledger
+`
+		sub := `2024/01/05 Store
+  Assets:Bank  -50,00 EUR = 900,00 EUR
+  Expenses:Food
+`
+		if err := os.WriteFile(ledgerFile, []byte(main), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(subFile, []byte(sub), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		_, err := New(ledgerFile, false, false, "")
+		want := subFile + ": ledger: line 2: balance assertion failed"
+		if err == nil || !contains(err.Error(), want) {
+			t.Errorf("New() error = %v, want error containing %q", err, want)
+		}
+	})
+
+	t.Run("merged files", func(t *testing.T) {
+		dir := t.TempDir()
+		file2023 := filepath.Join(dir, "2023.ledger")
+		file2024 := filepath.Join(dir, "2024.ledger")
+		if err := os.WriteFile(file2023, []byte(`2023/01/01 Opening balance
+  Assets:Bank  1000,00 EUR
+  Equity:Opening
+`), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2024, []byte(`2024/01/05 Store
+  Assets:Bank  -50,00 EUR = 950,00 EUR
+  Expenses:Food
+
+2024/01/06 Store
+  Assets:Bank  -50,00 EUR = 850,00 EUR
+  Expenses:Food
+`), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		// on its own the 2024 file misses the carried over balance
+		if _, err := NewWithConfig(file2024, Config{}); err == nil {
+			t.Fatal("NewWithConfig() expected assertion error, got nil")
+		}
+
+		cfg := Config{DeferAssertions: true}
+		l, err := NewWithConfig(file2023, cfg)
+		if err != nil {
+			t.Fatalf("NewWithConfig() error: %v", err)
+		}
+		other, err := NewWithConfig(file2024, cfg)
+		if err != nil {
+			t.Fatalf("NewWithConfig() error: %v", err)
+		}
+		if err := l.Merge(other); err != nil {
+			t.Fatalf("Merge() error: %v", err)
+		}
+		err = l.ValidateAssertions()
+		want := file2024 + ": ledger: line 6: balance assertion failed for Assets:Bank: expected 850,00 EUR, got 900,00 EUR"
+		if err == nil || err.Error() != want {
+			t.Errorf("ValidateAssertions() error = %v, want %q", err, want)
+		}
+	})
+}
+
+func TestBalancesElidedFullPrecision(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `2024/01/05 Transfer
+  Assets:Bitcoin  0,12345678 BTC
+  Assets:Wallet
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	precision := 2
+	l, err := NewWithConfig(ledgerFile, Config{Precision: &precision})
+	if err != nil {
+		t.Fatalf("NewWithConfig() error: %v", err)
+	}
+	b := l.Balances()
+	if b["Assets:Wallet"]["BTC"] != -0.12345678 {
+		t.Errorf("Assets:Wallet = %v, want -0.12345678 BTC", b["Assets:Wallet"]["BTC"])
+	}
+	if sum := b["Assets:Bitcoin"]["BTC"] + b["Assets:Wallet"]["BTC"]; sum != 0 {
+		t.Errorf("entry does not net to zero, off by %v BTC", sum)
+	}
+}
+
+func TestNegativeAssetBalances(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity BTC
+commodity EUR
+
+account Assets:Bank
+account Assets:Bitcoin
+account Equity:Opening
+account Expenses:Food
+
+2024/01/01 Opening
+  Assets:Bank  100,00 EUR
+  Equity:Opening
+
+2024/01/02 Sell bitcoin that was never bought
+  Assets:Bitcoin  -0,5 BTC @ 40000 EUR
+  Assets:Bank
+
+2024/01/03 Restaurant
+  Expenses:Food  150,00 EUR
+  Assets:Bank
+
+2024/02/01 Grocery store
+  Expenses:Food  30000,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		negative := l.NegativeAssetBalances(time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC))
+		if len(negative) != 1 {
+			t.Fatalf("NegativeAssetBalances() = %v, want only Assets:Bitcoin", negative)
+		}
+		if negative["Assets:Bitcoin"]["BTC"] != -0.5 {
+			t.Errorf("Assets:Bitcoin = %v, want -0.5 BTC", negative["Assets:Bitcoin"])
+		}
+	})
+
+	t.Run("all entries", func(t *testing.T) {
+		negative := l.NegativeAssetBalances(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC))
+		if negative["Assets:Bank"]["EUR"] != -10050 {
+			t.Errorf("Assets:Bank = %v, want -10050 EUR", negative["Assets:Bank"])
+		}
+	})
+}
+
+func TestBalancesIgnoreFuture(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Rent
+
+2024/01/01 Rent
+  Expenses:Rent  500,00 EUR
+  Assets:Bank
+
+2024/02/01 Rent
+  Expenses:Rent  500,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC) }
+
+	l, err := NewWithConfig(ledgerFile, Config{IgnoreFuture: true})
+	if err != nil {
+		t.Fatalf("NewWithConfig() error: %v", err)
+	}
+	if len(l.Entries) != 2 {
+		t.Errorf("Entries len = %d, want 2", len(l.Entries))
+	}
+	if b := l.Balances(); b["Expenses:Rent"]["EUR"] != 500 {
+		t.Errorf("Expenses:Rent = %v, want 500 EUR", b["Expenses:Rent"]["EUR"])
+	}
+
+	l, err = NewWithConfig(ledgerFile, Config{})
+	if err != nil {
+		t.Fatalf("NewWithConfig() error: %v", err)
+	}
+	if b := l.Balances(); b["Expenses:Rent"]["EUR"] != 1000 {
+		t.Errorf("Expenses:Rent = %v, want 1000 EUR", b["Expenses:Rent"]["EUR"])
+	}
+}
@@ -0,0 +1,127 @@
+package ledger
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// qifDateFormat is the date format used in QIF files.
+const qifDateFormat = "01/02/2006"
+
+// formatExport formats f with a decimal point, in the declared precision of
+// commodity (see the format subdirective) or without losing digits otherwise.
+func (l *Ledger) formatExport(f float64, commodity string) string {
+	precision, ok := l.CommodityPrecision[commodity]
+	if !ok {
+		precision = -1 // as many digits as necessary
+	}
+	return strconv.FormatFloat(f, 'f', precision, 64)
+}
+
+// WriteQIF writes the postings to account as QIF bank transactions to w.
+// Every posting becomes a record with the entry date, the amount with a
+// decimal point (see formatExport), the payee, and the entry note as memo.
+// QIF has no notion of commodities, the amounts are written as they are.
+func (l *Ledger) WriteQIF(w io.Writer, account string) error {
+	if _, err := fmt.Fprintln(w, "!Type:Bank"); err != nil {
+		return err
+	}
+	entries := l.reportEntries()
+	for i := range entries {
+		e := &entries[i]
+		var amounts []string
+		e.postings(func(name, commodity string, amount float64) {
+			if name == account {
+				amounts = append(amounts, l.formatExport(amount, commodity))
+			}
+		})
+		for _, amount := range amounts {
+			if _, err := fmt.Fprintf(w, "D%s\nT%s\nP%s\n", e.Date.Format(qifDateFormat),
+				amount, e.Name); err != nil {
+				return err
+			}
+			if e.Note != "" {
+				if _, err := fmt.Fprintf(w, "M%s\n", e.Note); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintln(w, "^"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// csvHeader are the columns written by ExportCSV.
+var csvHeader = []string{
+	"date",
+	"effective date",
+	"name",
+	"account",
+	"amount",
+	"commodity",
+	"price type",
+	"price amount",
+	"price commodity",
+	"line",
+}
+
+// ExportCSV writes every posting of the ledger as a CSV row to w, preceded
+// by a header row (see csvHeader). Amounts are written with a decimal
+// point, in the declared precision of their commodity (see the format
+// subdirective) or without losing digits otherwise. Elided amounts are
+// filled in, an elided account balancing several commodities gets a row per
+// commodity. The line is the line of the posting.
+func (l *Ledger) ExportCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for i := range l.Entries {
+		e := &l.Entries[i]
+		var effectiveDate string
+		if !e.EffectiveDate.IsZero() {
+			effectiveDate = e.EffectiveDate.Format(DateFormat)
+		}
+		row := func(a *LedgerAccount, amount float64, commodity string, ln int) error {
+			var priceAmount string
+			if a.PriceType != "" {
+				priceAmount = l.formatExport(a.PriceAmount, a.PriceCommodity)
+			}
+			return cw.Write([]string{
+				e.Date.Format(DateFormat),
+				effectiveDate,
+				e.Name,
+				a.Name,
+				l.formatExport(amount, commodity),
+				commodity,
+				a.PriceType,
+				priceAmount,
+				a.PriceCommodity,
+				strconv.Itoa(ln),
+			})
+		}
+		for j := range e.Accounts {
+			a := &e.Accounts[j]
+			// postings directly follow the entry line
+			ln := e.LineNumber + 1 + j
+			if a.Commodity != "" {
+				if err := row(a, a.Amount, a.Commodity, ln); err != nil {
+					return err
+				}
+				continue
+			}
+			amounts := e.balancingAmounts()
+			for _, commodity := range sortedCommodities(amounts) {
+				if err := row(a, amounts[commodity], commodity, ln); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
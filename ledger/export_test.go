@@ -0,0 +1,96 @@
+package ledger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteQIF(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+  format 1,00 EUR
+
+account Assets:Bank
+account Expenses:Food
+account Income:Salary
+
+2024/01/05 Store | weekly groceries
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/31 Employer
+  Assets:Bank  3000,00 EUR
+  Income:Salary
+
+2024/02/01 Transfer
+  Assets:Bank  0,12345678 BTC
+  Assets:Wallet
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	want := "!Type:Bank\n" +
+		"D01/05/2024\nT-50.00\nPStore\nMweekly groceries\n^\n" +
+		"D01/31/2024\nT3000.00\nPEmployer\n^\n" +
+		"D02/01/2024\nT0.12345678\nPTransfer\n^\n"
+
+	// the display precision does not change the amounts
+	zero := 0
+	for _, precision := range []*int{nil, &zero} {
+		l, err := NewWithConfig(ledgerFile, Config{Precision: precision})
+		if err != nil {
+			t.Fatalf("NewWithConfig() error: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := l.WriteQIF(&buf, "Assets:Bank"); err != nil {
+			t.Fatalf("WriteQIF() error: %v", err)
+		}
+		if buf.String() != want {
+			t.Errorf("WriteQIF() =\n%s\nwant:\n%s", buf.String(), want)
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+  format 1,00 EUR
+
+2024/01/05=2024/01/06 Store, Inc.
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/10 Exchange
+  Assets:Wallet  0,01234567 BTC @ 40000,00 EUR
+  Assets:Cash  10,50 USD
+  Equity:Conversion
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := l.ExportCSV(&buf); err != nil {
+		t.Fatalf("ExportCSV() error: %v", err)
+	}
+	want := "date,effective date,name,account,amount,commodity,price type,price amount,price commodity,line\n" +
+		"2024/01/05,2024/01/06,\"Store, Inc.\",Expenses:Food,50.00,EUR,,,,5\n" +
+		"2024/01/05,2024/01/06,\"Store, Inc.\",Assets:Bank,-50.00,EUR,,,,6\n" +
+		"2024/01/10,,Exchange,Assets:Wallet,0.01234567,BTC,@,40000.00,EUR,9\n" +
+		"2024/01/10,,Exchange,Assets:Cash,10.5,USD,,,,10\n" +
+		"2024/01/10,,Exchange,Equity:Conversion,-493.83,EUR,,,,11\n" +
+		"2024/01/10,,Exchange,Equity:Conversion,-10.5,USD,,,,11\n"
+	if buf.String() != want {
+		t.Errorf("ExportCSV() =\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
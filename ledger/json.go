@@ -0,0 +1,172 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// formatJSONDate formats date in DateFormat, the zero date as "".
+func formatJSONDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(DateFormat)
+}
+
+// parseJSONDate parses a date in DateFormat, "" as the zero date.
+func parseJSONDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(DateFormat, date)
+}
+
+// MarshalJSON implements json.Marshaler, the lot date is written in
+// DateFormat.
+func (a LedgerAccount) MarshalJSON() ([]byte, error) {
+	type account LedgerAccount // without methods
+	return json.Marshal(struct {
+		account
+		LotDate string `json:"lotDate,omitempty"`
+	}{account(a), formatJSONDate(a.LotDate)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *LedgerAccount) UnmarshalJSON(b []byte) error {
+	type account LedgerAccount // without methods
+	var v struct {
+		account
+		LotDate string `json:"lotDate"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*a = LedgerAccount(v.account)
+	var err error
+	a.LotDate, err = parseJSONDate(v.LotDate)
+	return err
+}
+
+type plainEntry LedgerEntry // without methods
+
+// jsonEntry is the JSON representation of a LedgerEntry with its dates in
+// DateFormat.
+type jsonEntry struct {
+	plainEntry
+	Date          string `json:"date"`
+	EffectiveDate string `json:"effectiveDate,omitempty"`
+	AuxDate       string `json:"auxDate,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, dates are written in DateFormat.
+func (e LedgerEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEntry{
+		plainEntry:    plainEntry(e),
+		Date:          formatJSONDate(e.Date),
+		EffectiveDate: formatJSONDate(e.EffectiveDate),
+		AuxDate:       formatJSONDate(e.AuxDate),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *LedgerEntry) UnmarshalJSON(b []byte) error {
+	var v jsonEntry
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*e = LedgerEntry(v.plainEntry)
+	var err error
+	if e.Date, err = parseJSONDate(v.Date); err != nil {
+		return err
+	}
+	if e.EffectiveDate, err = parseJSONDate(v.EffectiveDate); err != nil {
+		return err
+	}
+	e.AuxDate, err = parseJSONDate(v.AuxDate)
+	return err
+}
+
+// jsonLedger is the JSON representation of a Ledger. The sets of
+// commodities, accounts, and tags are sorted arrays.
+type jsonLedger struct {
+	HeaderComments []string      `json:"headerComments,omitempty"`
+	Commodities    []string      `json:"commodities"`
+	Accounts       []string      `json:"accounts"`
+	Tags           []string      `json:"tags"`
+	Entries        []LedgerEntry `json:"entries"`
+	Filename       string        `json:"filename,omitempty"`
+	NoMetadata     []string      `json:"noMetadata,omitempty"`
+
+	DefaultCommodity   string         `json:"defaultCommodity,omitempty"`
+	CommodityPrecision map[string]int `json:"commodityPrecision,omitempty"`
+}
+
+// sortedKeys returns the keys of set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keySet returns the set of keys.
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+// MarshalJSON implements json.Marshaler. Commodities, accounts, tags, and
+// accounts without required metadata are written as sorted arrays. In-memory
+// file contents are not written.
+func (l *Ledger) MarshalJSON() ([]byte, error) {
+	entries := l.Entries
+	if entries == nil {
+		entries = []LedgerEntry{}
+	}
+	return json.Marshal(jsonLedger{
+		HeaderComments: l.HeaderComments,
+		Commodities:    sortedKeys(l.Commodities),
+		Accounts:       sortedKeys(l.Accounts),
+		Tags:           sortedKeys(l.Tags),
+		Entries:        entries,
+		Filename:       l.Filename,
+		NoMetadata:     sortedKeys(l.NoMetadata),
+
+		DefaultCommodity:   l.DefaultCommodity,
+		CommodityPrecision: l.CommodityPrecision,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It reconstructs the ledger
+// written by MarshalJSON without reparsing its source file, the header
+// metadata is derived from the header comments again.
+func (l *Ledger) UnmarshalJSON(b []byte) error {
+	var v jsonLedger
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("ledger: %s", err)
+	}
+	*l = Ledger{
+		HeaderComments: v.HeaderComments,
+		HeaderMeta:     make(map[string]string),
+		Commodities:    keySet(v.Commodities),
+		Accounts:       keySet(v.Accounts),
+		Tags:           keySet(v.Tags),
+		Entries:        v.Entries,
+		Filename:       v.Filename,
+		NoMetadata:     keySet(v.NoMetadata),
+
+		DefaultCommodity:   v.DefaultCommodity,
+		CommodityPrecision: v.CommodityPrecision,
+	}
+	for _, line := range l.HeaderComments {
+		l.parseHeaderMeta(line)
+	}
+	return nil
+}
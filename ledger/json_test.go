@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLedgerJSON(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `; owner: test
+
+commodity USD
+commodity EUR
+commodity BTC
+
+account Assets:Wallet
+account Assets:Bank
+account Expenses:Food
+
+tag invoice
+
+2024/01/05=2024/01/06 Store | weekly groceries
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+    ; invoice: 2024-001
+
+2024/01/10 Exchange
+  Assets:Wallet  0,01 BTC [2023/12/01] @ 40000,00 EUR
+  Assets:Bank  -400,00 EUR = -450,00 EUR
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	if !contains(string(b), `"commodities":["BTC","EUR","USD"]`) {
+		t.Errorf("commodities not a sorted array: %s", b)
+	}
+	if !contains(string(b), `"date":"2024/01/05","effectiveDate":"2024/01/06"`) {
+		t.Errorf("dates not in DateFormat: %s", b)
+	}
+	if !contains(string(b), `"lotDate":"2023/12/01"`) {
+		t.Errorf("lot date not in DateFormat: %s", b)
+	}
+
+	var got Ledger
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error: %v", err)
+	}
+	if !reflect.DeepEqual(got.Entries, l.Entries) {
+		t.Errorf("entries differ:\n%+v\nwant:\n%+v", got.Entries, l.Entries)
+	}
+	if !reflect.DeepEqual(got.Commodities, l.Commodities) ||
+		!reflect.DeepEqual(got.Accounts, l.Accounts) ||
+		!reflect.DeepEqual(got.Tags, l.Tags) {
+		t.Errorf("declarations differ: %v %v %v", got.Commodities, got.Accounts, got.Tags)
+	}
+	if got.HeaderMeta["owner"] != "test" {
+		t.Errorf("HeaderMeta = %v, want owner: test", got.HeaderMeta)
+	}
+	if got.Filename != ledgerFile {
+		t.Errorf("Filename = %s, want %s", got.Filename, ledgerFile)
+	}
+
+	t.Run("invalid date", func(t *testing.T) {
+		var l Ledger
+		err := json.Unmarshal([]byte(`{"entries":[{"date":"2024-01-05"}]}`), &l)
+		if err == nil {
+			t.Error("json.Unmarshal() expected error for invalid date, got nil")
+		}
+	})
+}
@@ -2,9 +2,14 @@ package ledger
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,57 +24,191 @@ const DateFormat = "2006/01/02"
 // AccountWidth is the width of the account column in the ledger.
 const AccountWidth = 46
 
+// DefaultPrecision is the default number of decimal places amounts are
+// printed with.
+const DefaultPrecision = 2
+
 // invoiceSubtree is the directory containing the invoice PDFs.
 const invoiceSubtree = "invoices"
 
 // LedgerAccount defines a single account in a ledger entry.
 type LedgerAccount struct {
-	Name           string
-	Amount         float64
-	Commodity      string
-	PriceType      string  // "", "@" (per-unit), or "@@" (total cost)
-	PriceAmount    float64
-	PriceCommodity string
-	Elided         bool    // true if amount was originally elided (not specified in input)
+	Name           string    `json:"name"`
+	Amount         float64   `json:"amount"`
+	Commodity      string    `json:"commodity,omitempty"`
+	PriceType      string    `json:"priceType,omitempty"` // "", "@" (per-unit), or "@@" (total cost)
+	PriceAmount    float64   `json:"priceAmount,omitempty"`
+	PriceCommodity string    `json:"priceCommodity,omitempty"`
+	LotDate        time.Time `json:"lotDate"` // optional lot acquisition date from a "[DATE]" annotation
+
+	// optional balance assertion "= AssertionAmount AssertionCommodity"
+	AssertionAmount    float64 `json:"assertionAmount,omitempty"`
+	AssertionCommodity string  `json:"assertionCommodity,omitempty"`
+
+	Elided bool   `json:"elided,omitempty"` // true if amount was originally elided (not specified in input)
+	Note   string `json:"note,omitempty"`   // optional trailing "; note" (see Config.PostingNotes)
+}
+
+// DisplaySign returns -1 for accounts with a credit balance in normal use
+// (Income: and Liabilities:) and 1 otherwise. Multiplying an amount with the
+// display sign of its account shows earnings and debts as positive figures.
+func DisplaySign(account string) float64 {
+	if strings.HasPrefix(account, "Income:") ||
+		strings.HasPrefix(account, "Liabilities:") {
+		return -1
+	}
+	return 1
+}
+
+// DisplayAmount returns the amount of the account with the sign convention
+// of DisplaySign applied, for use in reports.
+func (a *LedgerAccount) DisplayAmount() float64 {
+	return DisplaySign(a.Name) * a.Amount
+}
+
+// countWriter writes to w and counts the written bytes. After the first
+// error all writes are skipped and the error is kept in err.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) printf(format string, a ...any) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, a...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (cw *countWriter) println(a ...any) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintln(cw.w, a...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// defaultFormat formats amount with DefaultPrecision decimal places for
+// every commodity.
+func defaultFormat(amount float64, commodity string) string {
+	return formatAmount(amount, DefaultPrecision)
+}
+
+// formatAmount formats amount with precision decimal places and a decimal
+// comma.
+func formatAmount(amount float64, precision int) string {
+	return strings.ReplaceAll(strconv.FormatFloat(amount, 'f', precision, 64), ".", ",")
+}
+
+// formatAmountExact formats amount like formatAmount, but with as many
+// decimal places as needed to parse back to the same amount, if precision
+// does not suffice.
+func formatAmountExact(amount float64, precision int) string {
+	s := strconv.FormatFloat(amount, 'f', precision, 64)
+	if f, err := strconv.ParseFloat(s, 64); err != nil || f != amount {
+		s = strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+	return strings.ReplaceAll(s, ".", ",")
 }
 
 // Print prints the LedgerAccount to stdout.
 func (a *LedgerAccount) Print() {
-	if a.Elided {
-		// Print without amount if it was originally elided
-		fmt.Printf("  %s\n", a.Name)
-	} else if a.Commodity != "" {
+	a.write(&countWriter{w: os.Stdout}, defaultFormat)
+}
+
+// write writes the LedgerAccount to w with amounts formatted by format.
+func (a *LedgerAccount) write(w *countWriter, format func(amount float64, commodity string) string) {
+	line := "  " + a.Name
+	// print without amount if it was originally elided
+	if !a.Elided && a.Commodity != "" {
 		padding := AccountWidth - len(a.Name)
 		if padding < 1 {
 			padding = 1
 		}
 		buf := strings.Repeat(" ", padding)
-		printSum := strings.ReplaceAll(fmt.Sprintf("%.2f", a.Amount), ".", ",")
+		printSum := format(a.Amount, a.Commodity)
+		line += fmt.Sprintf("%s  %s %s", buf, printSum, a.Commodity)
+		if !a.LotDate.IsZero() {
+			line += " [" + a.LotDate.Format(DateFormat) + "]"
+		}
 		if a.PriceType != "" {
-			printPrice := strings.ReplaceAll(fmt.Sprintf("%.2f", a.PriceAmount), ".", ",")
-			fmt.Printf("  %s%s  %s %s %s %s %s\n",
-				a.Name, buf, printSum, a.Commodity,
-				a.PriceType, printPrice, a.PriceCommodity)
-		} else {
-			fmt.Printf("  %s%s  %s %s\n", a.Name, buf, printSum, a.Commodity)
+			printPrice := format(a.PriceAmount, a.PriceCommodity)
+			line += fmt.Sprintf(" %s %s %s", a.PriceType, printPrice, a.PriceCommodity)
 		}
-	} else {
-		fmt.Printf("  %s\n", a.Name)
 	}
+	if a.AssertionCommodity != "" {
+		line += fmt.Sprintf(" = %s %s",
+			format(a.AssertionAmount, a.AssertionCommodity),
+			a.AssertionCommodity)
+	}
+	if a.Note != "" {
+		line += "  ; " + a.Note
+	}
+	w.println(line)
 }
 
 // LedgerEntry represents a single entry in the ledger with one or more accounts.
 type LedgerEntry struct {
-	Date          time.Time
-	EffectiveDate time.Time
-	Name          string
-	Accounts      []LedgerAccount
-	Metadata      map[string]string // optional
+	Date          time.Time         `json:"date"`
+	EffectiveDate time.Time         `json:"effectiveDate"`
+	Name          string            `json:"name"`           // payee
+	Note          string            `json:"note,omitempty"` // optional note following the payee after a "|"
+	AuxDate       time.Time         `json:"auxDate"`        // optional secondary date from "; date:" metadata
+	Accounts      []LedgerAccount   `json:"accounts"`
+	Metadata      map[string]string `json:"metadata,omitempty"` // optional
+	LineNumber    int               `json:"lineNumber"`         // first line of the entry in the file
+	EndLineNumber int               `json:"endLineNumber"`      // last line of the entry in the file
+
+	file string // file the entry was parsed from, if it is not the ledger file
+}
+
+// currentDate returns the effective date of the entry, if set, and the
+// accounting date otherwise. Entries are ordered by this date.
+func (e *LedgerEntry) currentDate() time.Time {
+	if e.EffectiveDate.IsZero() {
+		return e.Date
+	}
+	return e.EffectiveDate
+}
+
+// ID returns a deterministic ID of the entry: the hex encoded SHA256 hash
+// of its dates, payee, and postings. Metadata and line numbers are not
+// included, so the ID is stable across reparses of an edited file, while
+// changing a posting changes the ID. The order of the postings does not
+// matter.
+func (e *LedgerEntry) ID() string {
+	var postings []string
+	for _, a := range e.Accounts {
+		postings = append(postings, strings.Join([]string{
+			a.Name,
+			strconv.FormatFloat(a.Amount, 'g', -1, 64),
+			a.Commodity,
+			a.PriceType,
+			strconv.FormatFloat(a.PriceAmount, 'g', -1, 64),
+			a.PriceCommodity,
+		}, "\x00"))
+	}
+	sort.Strings(postings)
+	var effectiveDate string
+	if !e.EffectiveDate.IsZero() {
+		effectiveDate = e.EffectiveDate.Format(DateFormat)
+	}
+	fields := append([]string{e.Date.Format(DateFormat), effectiveDate, e.Name}, postings...)
+	return file.SHA256Bytes([]byte(strings.Join(fields, "\n")))
 }
 
 // balanceEpsilon is the tolerance for floating-point balance comparisons.
 const balanceEpsilon = 0.005
 
+// residuePrecision is the number of decimal places inferred amounts are
+// rounded to, if their commodity has no declared precision. It is finer than
+// any commodity's smallest unit (e.g. 8 for BTC).
+const residuePrecision = 9
+
 // balanceAmount returns the amount and commodity to use for balance calculation.
 // If the account has a price annotation, the amount is converted to the price commodity:
 //   - @ (per-unit): returns Amount * PriceAmount in PriceCommodity
@@ -99,7 +238,30 @@ func (a *LedgerAccount) balanceAmount() (float64, string) {
 // Price annotations affect balance calculation:
 //   - @ (per-unit): 10 BTC @ 50000 EUR contributes 500000 EUR to balance
 //   - @@ (total cost): 10 BTC @@ 500000 EUR contributes 500000 EUR to balance
-func (e *LedgerEntry) validateBalance(startLine int) error {
+//
+// If warnFraction is positive, a warning is printed for entries which
+// balance within balanceEpsilon, but whose imbalance exceeds warnFraction of
+// the largest posting. This catches errors in small entries masked by the
+// absolute epsilon.
+//
+// The tolerance can be widened for a single entry with "tolerance"
+// metadata (see balanceTolerance).
+//
+// An elided amount in a single commodity is rounded to remove floating-point
+// residue from price conversions: to the commodity's declared precision in
+// commodityPrecision (see the format subdirective), if any, and to
+// residuePrecision decimal places otherwise. The display precision is not
+// used, it would change parsed amounts.
+func (e *LedgerEntry) validateBalance(
+	startLine int,
+	warnFraction float64,
+	commodityPrecision map[string]int,
+) error {
+	tolerance, err := e.balanceTolerance(startLine)
+	if err != nil {
+		return err
+	}
+
 	// Find accounts with elided amounts (no commodity set)
 	var elidedIdx = -1
 	for i, a := range e.Accounts {
@@ -127,9 +289,15 @@ func (e *LedgerEntry) validateBalance(startLine int) error {
 			return fmt.Errorf("ledger: line %d: cannot infer elided amount without other amounts", startLine)
 		}
 		if len(sums) == 1 {
-			// Single commodity: set the elided amount to balance the entry
+			// Single commodity: set the elided amount to balance the entry,
+			// rounded to get rid of residue from price conversions
 			for commodity, sum := range sums {
-				e.Accounts[elidedIdx].Amount = -sum
+				decimals, ok := commodityPrecision[commodity]
+				if !ok {
+					decimals = residuePrecision
+				}
+				scale := math.Pow(10, float64(decimals))
+				e.Accounts[elidedIdx].Amount = math.Round(-sum*scale) / scale
 				e.Accounts[elidedIdx].Commodity = commodity
 			}
 		}
@@ -151,25 +319,112 @@ func (e *LedgerEntry) validateBalance(startLine int) error {
 
 	// Single commodity: verify it sums to zero
 	for commodity, sum := range sums {
-		if sum < -balanceEpsilon || sum > balanceEpsilon {
+		if sum < -tolerance || sum > tolerance {
 			return fmt.Errorf("ledger: line %d: entry not balanced for %s (off by %.2f)",
 				startLine, commodity, sum)
 		}
+		if warnFraction > 0 && sum != 0 {
+			largest := 0.0
+			for i := range e.Accounts {
+				amount, _ := e.Accounts[i].balanceAmount()
+				largest = math.Max(largest, math.Abs(amount))
+			}
+			if math.Abs(sum) > warnFraction*largest {
+				warning(fmt.Sprintf("line %d: entry balanced within epsilon, but off by %g %s relative to largest posting %g %s",
+					startLine, sum, commodity, largest, commodity))
+			}
+		}
+	}
+
+	return nil
+}
+
+// balanceTolerance returns the tolerance for the balance check of the
+// entry. A "; tolerance: 0.02" metadata tag allows a known rounding
+// difference in a single entry, e.g. from a currency conversion rounded by
+// the bank. The tag only widens the tolerance, values below balanceEpsilon
+// have no effect.
+func (e *LedgerEntry) balanceTolerance(startLine int) (float64, error) {
+	value, ok := e.Metadata["tolerance"]
+	if !ok {
+		return balanceEpsilon, nil
 	}
+	tolerance, err := parseAmount(value)
+	if err != nil || tolerance < 0 {
+		return 0, fmt.Errorf("ledger: line %d: invalid tolerance metadata: %s", startLine, value)
+	}
+	return math.Max(tolerance, balanceEpsilon), nil
+}
 
+// validateTransfers checks that in-kind transfers within a multi-commodity
+// entry balance. validateBalance skips entries with multiple commodities,
+// because they are usually exchanges between commodities. A commodity which
+// is posted both with positive and negative amounts is transferred instead
+// and must sum to zero. Entries can be marked explicitly with the metadata
+// "transfer: true" (every commodity must sum to zero) or "exchange: true"
+// (no commodity is checked).
+func (e *LedgerEntry) validateTransfers(startLine int) error {
+	if e.Metadata["exchange"] == "true" {
+		return nil
+	}
+	transfer := e.Metadata["transfer"] == "true"
+	sums := make(map[string]float64)
+	positive := make(map[string]bool)
+	negative := make(map[string]bool)
+	for _, a := range e.Accounts {
+		if a.Commodity == "" {
+			// elided account balances every commodity
+			return nil
+		}
+		amount, commodity := a.balanceAmount()
+		sums[commodity] += amount
+		if amount > 0 {
+			positive[commodity] = true
+		} else if amount < 0 {
+			negative[commodity] = true
+		}
+	}
+	if len(sums) < 2 {
+		// single commodity entries are checked by validateBalance
+		return nil
+	}
+	var commodities []string
+	for commodity := range sums {
+		commodities = append(commodities, commodity)
+	}
+	sort.Strings(commodities)
+	for _, commodity := range commodities {
+		if !transfer && !(positive[commodity] && negative[commodity]) {
+			continue
+		}
+		sum := sums[commodity]
+		if sum < -balanceEpsilon || sum > balanceEpsilon {
+			return fmt.Errorf("ledger: line %d: transfer not balanced for %s (off by %.2f)",
+				startLine, commodity, sum)
+		}
+	}
 	return nil
 }
 
 // Print prints the LedgerEntry to stdout.
 func (e *LedgerEntry) Print() {
+	e.write(&countWriter{w: os.Stdout}, defaultFormat)
+}
+
+// write writes the LedgerEntry to w with amounts formatted by format.
+func (e *LedgerEntry) write(w *countWriter, format func(amount float64, commodity string) string) {
+	name := e.Name
+	if e.Note != "" {
+		name += " | " + e.Note
+	}
 	if e.EffectiveDate.IsZero() {
-		fmt.Printf("%s %s\n", e.Date.Format(DateFormat), e.Name)
+		w.printf("%s %s\n", e.Date.Format(DateFormat), name)
 	} else {
-		fmt.Printf("%s=%s %s\n", e.Date.Format(DateFormat),
-			e.EffectiveDate.Format(DateFormat), e.Name)
+		w.printf("%s=%s %s\n", e.Date.Format(DateFormat),
+			e.EffectiveDate.Format(DateFormat), name)
 	}
 	for _, a := range e.Accounts {
-		a.Print()
+		a.write(w, format)
 	}
 	if e.Metadata != nil {
 		var tags []string
@@ -178,7 +433,7 @@ func (e *LedgerEntry) Print() {
 		}
 		sort.Strings(tags)
 		for _, tag := range tags {
-			fmt.Printf("    ; %s: %s\n", tag, e.Metadata[tag])
+			w.printf("    ; %s: %s\n", tag, e.Metadata[tag])
 		}
 	}
 }
@@ -199,7 +454,37 @@ func (e *LedgerEntry) parseMetadata(line string, ln int) error {
 	return nil
 }
 
-func procFilename(filename string) error {
+// sha256Sum returns the SHA256 hash of filename. If contents holds the
+// content of filename it is hashed directly instead of reading the file.
+func sha256Sum(filename string, contents map[string][]byte) (string, error) {
+	if content, ok := contents[filename]; ok {
+		return file.SHA256Bytes(content), nil
+	}
+	return file.SHA256Sum(filename)
+}
+
+// parseAuxDate sets the AuxDate of the entry from its "date" metadata, which
+// records a secondary date like the settlement date of a trade.
+func (e *LedgerEntry) parseAuxDate(startLine int) error {
+	date, ok := e.Metadata["date"]
+	if !ok {
+		return nil
+	}
+	var err error
+	e.AuxDate, err = time.Parse(DateFormat, date)
+	if err != nil {
+		return fmt.Errorf("ledger: line %d: invalid date metadata: %s", startLine, err)
+	}
+	return nil
+}
+
+func procFilename(filename string, contents map[string][]byte) error {
+	if _, ok := contents[filename]; ok {
+		if !strings.HasSuffix(filename, ".pdf") {
+			return fmt.Errorf("ledger: file is not a PDF: %s", filename)
+		}
+		return nil
+	}
 	exists, err := file.Exists(filename)
 	if err != nil {
 		return err
@@ -219,12 +504,13 @@ func (e *LedgerEntry) procHash(
 	strict bool,
 	addMissingHashes bool,
 	ln int,
+	contents map[string][]byte,
 ) error {
 	hash, ok := e.Metadata[metadataKey]
 	if ok {
 		if strict {
 			// check hash
-			h, err := file.SHA256Sum(filename)
+			h, err := sha256Sum(filename, contents)
 			if err != nil {
 				return err
 			}
@@ -236,7 +522,7 @@ func (e *LedgerEntry) procHash(
 	} else {
 		if addMissingHashes {
 			// add missing SHA256 hash
-			h, err := file.SHA256Sum(filename)
+			h, err := sha256Sum(filename, contents)
 			if err != nil {
 				return err
 			}
@@ -248,17 +534,40 @@ func (e *LedgerEntry) procHash(
 	return nil
 }
 
+// needsAttachment returns true, if the entry has no file metadata but
+// requires it: entries posting to expenses or income need an attachment,
+// unless one of their accounts is listed in noMetadata.
+func (e *LedgerEntry) needsAttachment(noMetadata map[string]bool) bool {
+	if _, ok := e.Metadata["file"]; ok {
+		return false
+	}
+	for _, a := range e.Accounts {
+		if noMetadata[a.Name] {
+			return false
+		}
+	}
+	// only enforce metadata lines for expenses or income
+	for _, a := range e.Accounts {
+		if strings.HasPrefix(a.Name, "Expenses:") ||
+			strings.HasPrefix(a.Name, "Income:") {
+			return true
+		}
+	}
+	return false
+}
+
 // procMetadata checks if a single ledger entry has metadata and validates it.
 func (e *LedgerEntry) procMetadata(
 	strict, addMissingHashes bool,
 	ln int,
 	noMetadata map[string]bool,
+	contents map[string][]byte,
 ) error {
 	filenameDefined := false
 	if e.Metadata != nil {
 		filename, ok := e.Metadata["file"]
 		if ok {
-			if err := procFilename(filename); err != nil {
+			if err := procFilename(filename, contents); err != nil {
 				return err
 			}
 			filenameDefined = true
@@ -268,16 +577,16 @@ func (e *LedgerEntry) procMetadata(
 			if !filenameDefined {
 				return fmt.Errorf("ledger: line %d: 'fileTwo' defined but not 'file'", ln)
 			}
-			if err := procFilename(filenameTwo); err != nil {
+			if err := procFilename(filenameTwo, contents); err != nil {
 				return err
 			}
 		}
-		err := e.procHash("sha256", filename, strict, addMissingHashes, ln)
+		err := e.procHash("sha256", filename, strict, addMissingHashes, ln, contents)
 		if err != nil {
 			return err
 		}
 		if filenameTwo != "" {
-			err = e.procHash("sha256Two", filenameTwo, strict, addMissingHashes, ln)
+			err = e.procHash("sha256Two", filenameTwo, strict, addMissingHashes, ln, contents)
 			if err != nil {
 				return err
 			}
@@ -285,29 +594,9 @@ func (e *LedgerEntry) procMetadata(
 	}
 
 	// make sure file metadata is defined where needed
-	if !filenameDefined {
-		skip := false
-		for _, a := range e.Accounts {
-			if noMetadata[a.Name] {
-				skip = true
-				break
-			}
-		}
-		if !skip {
-			// only enforce metadata lines for expenses or income
-			hasExpenseOrIncome := false
-			for _, a := range e.Accounts {
-				if strings.HasPrefix(a.Name, "Expenses:") ||
-					strings.HasPrefix(a.Name, "Income:") {
-					hasExpenseOrIncome = true
-					break
-				}
-			}
-			if hasExpenseOrIncome {
-				warning(fmt.Sprintf("file metadata missing for: %s %s",
-					e.Date.Format(DateFormat), e.Name))
-			}
-		}
+	if !filenameDefined && e.needsAttachment(noMetadata) {
+		warning(fmt.Sprintf("file metadata missing for: %s %s",
+			e.Date.Format(DateFormat), e.Name))
 	}
 
 	return nil
@@ -325,13 +614,104 @@ const (
 // accounts, and entries.
 type Ledger struct {
 	HeaderComments []string
+	HeaderMeta     map[string]string // "; key: value" header comments
 	Commodities    map[string]bool
 	Accounts       map[string]bool
 	Tags           map[string]bool
 	Entries        []LedgerEntry
+	Filename       string // file the ledger was read from
+
+	// DefaultCommodity is set by a "D" directive, see parseAccount.
+	DefaultCommodity string
+
+	// CommodityPrecision holds the number of decimal places of commodities
+	// declared with a format subdirective, see parseCommodityFormat.
+	CommodityPrecision map[string]int
 
 	// config
 	NoMetadata map[string]bool
+	Contents   map[string][]byte // in-memory file contents keyed by path
+
+	config   Config   // options the ledger was parsed with
+	lines    int      // number of lines parsed so far
+	includes []string // stack of files being parsed, see include
+	applied  []string // prefixes of apply account still open, see AppendFrom
+}
+
+// Config defines the options used when parsing a ledger.
+type Config struct {
+	Strict             bool   // validate the ledger more strictly
+	AddMissingHashes   bool   // add missing SHA256 hashes to file metadata
+	NoMetadataFilename string // file listing accounts without required metadata
+
+	// Precision is the number of decimal places amounts are printed with,
+	// nil selects DefaultPrecision. It must not be negative.
+	Precision *int
+
+	// BalanceWarnFraction enables a warning for entries which balance
+	// within the absolute balance epsilon, but whose imbalance exceeds this
+	// fraction of the largest posting (e.g. 0.0001). 0 disables the warning.
+	BalanceWarnFraction float64
+
+	// StrictTransfers checks that commodities transferred in kind within
+	// multi-commodity entries balance (see LedgerEntry.validateTransfers).
+	StrictTransfers bool
+
+	// StrictEffectiveDates rejects entries whose effective date precedes
+	// their accounting date, which is usually a typo. It is off by default,
+	// because some workflows legitimately backdate entries.
+	StrictEffectiveDates bool
+
+	// IgnoreFuture excludes entries dated after the current date from
+	// reports. Future entries are still parsed and validated.
+	IgnoreFuture bool
+
+	// PostingNotes accepts a trailing "; note" comment on posting lines,
+	// e.g. a note-only posting like "Assets:Bank  ; moved to savings". The
+	// comment is stored in LedgerAccount.Note. By default, postings must
+	// consist of 1, 3, or 6 fields.
+	PostingNotes bool
+
+	// DeferAssertions skips checking balance assertions after parsing. Set
+	// it when the ledger is merged with others (see Merge), because an
+	// assertion can depend on entries of another file, and call
+	// Ledger.ValidateAssertions after merging.
+	DeferAssertions bool
+
+	// DeferMetadata skips the strict mode metadata checks after parsing,
+	// for the same reason: a file can be referenced from several merged
+	// ledgers. Call Ledger.ValidateMetadata after merging.
+	DeferMetadata bool
+
+	// CommodityAliases maps commodity symbols to their canonical symbol,
+	// e.g. "XBT" to "BTC". Aliases in postings and price annotations are
+	// replaced before the commodity is checked against the declarations.
+	CommodityAliases map[string]string
+
+	// FiscalYearStartMonth and FiscalYearStartDay define the first day of
+	// the fiscal year (e.g. April 6 for the UK tax year). Zero values select
+	// January 1.
+	FiscalYearStartMonth time.Month
+	FiscalYearStartDay   int
+
+	// Contents optionally provides the content of files referenced in the
+	// metadata, keyed by path. Files found here are hashed from memory instead
+	// of being read from disk.
+	Contents map[string][]byte
+}
+
+// parseAmount parses an amount with a decimal comma or point. Scientific
+// notation like 5e-08 or 1,5E+6 is accepted, while the special values and
+// hexadecimal forms strconv.ParseFloat understands (Inf, NaN, 0x1p-2) are
+// rejected.
+func parseAmount(s string) (float64, error) {
+	amount := strings.ReplaceAll(s, ",", ".")
+	for _, r := range amount {
+		if !strings.ContainsRune("0123456789.+-eE", r) {
+			return 0, &strconv.NumError{Func: "ParseFloat", Num: amount, Err: strconv.ErrSyntax}
+		}
+	}
+	return strconv.ParseFloat(amount, 64)
 }
 
 // parseAccount parses a single account line and returns a LedgerAccount.
@@ -340,16 +720,58 @@ type Ledger struct {
 //   - AccountName Amount Commodity
 //   - AccountName Amount Commodity @ PriceAmount PriceCommodity (per-unit price)
 //   - AccountName Amount Commodity @@ PriceAmount PriceCommodity (total cost)
+//
+// A lot date [YYYY/MM/DD] may follow the Commodity, e.g. for a transfer-in
+// of a lot acquired before the entry date. A balance assertion
+// "= Amount Commodity" may end the line (see Ledger.ValidateAssertions).
+//
+// If defaultCommodity is set (see the D directive), the Commodity of an
+// amount can be omitted. An AccountName without amount is still elided.
 func parseAccount(
 	line string,
 	ln int,
 	strict bool,
 	commodities map[string]bool,
 	accounts map[string]bool,
+	commodityAliases map[string]string,
+	defaultCommodity string,
 ) (LedgerAccount, error) {
 	var a LedgerAccount
 
 	elems := strings.Fields(line)
+	// an optional balance assertion "= Amount Commodity" ends the posting
+	if n := len(elems); n >= 4 && elems[n-3] == "=" {
+		var err error
+		a.AssertionAmount, err = parseAmount(elems[n-2])
+		if err != nil {
+			return a, fmt.Errorf("ledger: line %d: invalid balance assertion: %s", ln, err)
+		}
+		commodity := elems[n-1]
+		if canonical, ok := commodityAliases[commodity]; ok {
+			commodity = canonical
+		}
+		if strict && !commodities[commodity] {
+			return a, fmt.Errorf("ledger: line %d: assertion commodity unknown: %s", ln, commodity)
+		}
+		a.AssertionCommodity = commodity
+		elems = elems[:n-3]
+	}
+	// an optional lot date like [2023/12/01] follows the commodity
+	if len(elems) == 4 || len(elems) == 7 {
+		token := elems[3]
+		if strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]") {
+			var err error
+			a.LotDate, err = time.Parse(DateFormat, token[1:len(token)-1])
+			if err != nil {
+				return a, fmt.Errorf("ledger: line %d: invalid lot date: %s", ln, err)
+			}
+			elems = append(elems[:3], elems[4:]...)
+		}
+	}
+	// an amount without commodity is in the default commodity
+	if len(elems) == 2 && defaultCommodity != "" {
+		elems = append(elems, defaultCommodity)
+	}
 	if len(elems) != 1 && len(elems) != 3 && len(elems) != 6 {
 		return a, fmt.Errorf("ledger: line %d: invalid account format (expected 1, 3, or 6 elements, got %d)", ln, len(elems))
 	}
@@ -360,13 +782,15 @@ func parseAccount(
 	a.Name = account
 
 	if len(elems) >= 3 {
-		amount := strings.ReplaceAll(elems[1], ",", ".")
 		var err error
-		a.Amount, err = strconv.ParseFloat(amount, 64)
+		a.Amount, err = parseAmount(elems[1])
 		if err != nil {
 			return a, fmt.Errorf("ledger: line %d: %s", ln, err)
 		}
 		commodity := elems[2]
+		if canonical, ok := commodityAliases[commodity]; ok {
+			commodity = canonical
+		}
 		if strict && !commodities[commodity] {
 			return a, fmt.Errorf("ledger: line %d: commodity unknown: %s", ln, commodity)
 		}
@@ -381,14 +805,16 @@ func parseAccount(
 		}
 		a.PriceType = priceType
 
-		priceAmount := strings.ReplaceAll(elems[4], ",", ".")
 		var err error
-		a.PriceAmount, err = strconv.ParseFloat(priceAmount, 64)
+		a.PriceAmount, err = parseAmount(elems[4])
 		if err != nil {
 			return a, fmt.Errorf("ledger: line %d: invalid price amount: %s", ln, err)
 		}
 
 		priceCommodity := elems[5]
+		if canonical, ok := commodityAliases[priceCommodity]; ok {
+			priceCommodity = canonical
+		}
 		if strict && !commodities[priceCommodity] {
 			return a, fmt.Errorf("ledger: line %d: price commodity unknown: %s", ln, priceCommodity)
 		}
@@ -399,16 +825,20 @@ func parseAccount(
 }
 
 // parseEntry parses a single entry and returns the corresponding LedgerEntry.
+// If accountPrefix is not empty, it is prepended to all account names (see
+// the apply account directive).
 func parseEntry(
 	scanner *bufio.Scanner,
 	line string,
 	ln *int,
 	previousDate *time.Time,
-	strict bool,
-	addMissingHashes bool,
+	cfg Config,
 	commodities map[string]bool,
 	accounts map[string]bool,
 	noMetadata map[string]bool,
+	accountPrefix string,
+	defaultCommodity string,
+	commodityPrecision map[string]int,
 ) (*LedgerEntry, error) {
 	var (
 		e         LedgerEntry
@@ -437,6 +867,10 @@ func parseEntry(
 		if err != nil {
 			return nil, fmt.Errorf("ledger: line %d: %s", *ln, err)
 		}
+		if cfg.StrictEffectiveDates && e.EffectiveDate.Before(e.Date) {
+			return nil, fmt.Errorf("ledger: line %d: effective date %s is before %s", *ln,
+				e.EffectiveDate.Format(DateFormat), e.Date.Format(DateFormat))
+		}
 	} else {
 		// parse without effective date
 		e.Date, err = time.Parse(DateFormat, date)
@@ -444,7 +878,13 @@ func parseEntry(
 			return nil, fmt.Errorf("ledger: line %d: %s", *ln, err)
 		}
 	}
+	// split payee and note
+	if i := strings.Index(name, "|"); i >= 0 {
+		e.Note = strings.TrimSpace(name[i+1:])
+		name = strings.TrimSpace(name[:i])
+	}
 	e.Name = name
+	e.LineNumber = startLine
 
 	// make sure dates are in ascending order
 	var currentDate time.Time
@@ -470,16 +910,27 @@ func parseEntry(
 		(*ln)++
 		if line == "" {
 			// entry finished - validate balance and metadata
-			if err := e.validateBalance(startLine); err != nil {
+			e.EndLineNumber = *ln - 1
+			if err := e.validateBalance(startLine, cfg.BalanceWarnFraction, commodityPrecision); err != nil {
 				return nil, err
 			}
-			if err := e.procMetadata(strict, addMissingHashes, *ln-1, noMetadata); err != nil {
+			if cfg.StrictTransfers {
+				if err := e.validateTransfers(startLine); err != nil {
+					return nil, err
+				}
+			}
+			if err := e.parseAuxDate(startLine); err != nil {
+				return nil, err
+			}
+			if err := e.procMetadata(cfg.Strict, cfg.AddMissingHashes, *ln-1,
+				noMetadata, cfg.Contents); err != nil {
 				return nil, err
 			}
 			return &e, nil
 		}
 
-		if !strings.HasPrefix(line, "  ") {
+		// postings are indented by at least two spaces or a tab
+		if !strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "\t") {
 			return nil, fmt.Errorf("ledger: line %d: not an account line", *ln)
 		}
 
@@ -496,10 +947,22 @@ func parseEntry(
 			if metadataMode {
 				return nil, fmt.Errorf("ledger: line %d: already parsing metadata", *ln)
 			}
-			a, err := parseAccount(line, *ln, strict, commodities, accounts)
+			var note string
+			if cfg.PostingNotes {
+				if i := strings.Index(line, ";"); i >= 0 {
+					note = strings.TrimSpace(line[i+1:])
+					line = strings.TrimSpace(line[:i])
+				}
+			}
+			if accountPrefix != "" {
+				line = accountPrefix + ":" + line
+			}
+			a, err := parseAccount(line, *ln, cfg.Strict, commodities, accounts,
+				cfg.CommodityAliases, defaultCommodity)
 			if err != nil {
 				return nil, err
 			}
+			a.Note = note
 			e.Accounts = append(e.Accounts, a)
 		}
 	}
@@ -507,7 +970,16 @@ func parseEntry(
 		return nil, err
 	}
 	// last entry in file (no trailing newline) - validate balance
-	if err := e.validateBalance(startLine); err != nil {
+	e.EndLineNumber = *ln
+	if err := e.validateBalance(startLine, cfg.BalanceWarnFraction, commodityPrecision); err != nil {
+		return nil, err
+	}
+	if cfg.StrictTransfers {
+		if err := e.validateTransfers(startLine); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.parseAuxDate(startLine); err != nil {
 		return nil, err
 	}
 	return &e, nil
@@ -523,12 +995,11 @@ func (l *Ledger) parseNoMetadataFile(noMetadataFilename string) error {
 	if noMetadataFilename == "" {
 		return nil
 	}
-	fp, err := os.Open(noMetadataFilename)
+	b, err := file.Read(noMetadataFilename)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	scanner := bufio.NewScanner(fp)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
 	for scanner.Scan() {
 		l.NoMetadata[scanner.Text()] = true
 	}
@@ -544,22 +1015,119 @@ func New(
 	strict, addMissingHashes bool,
 	noMetadataFilename string,
 ) (*Ledger, error) {
+	return NewWithConfig(filename, Config{
+		Strict:             strict,
+		AddMissingHashes:   addMissingHashes,
+		NoMetadataFilename: noMetadataFilename,
+	})
+}
+
+// NewWithConfig creates a new Ledger from a file using the options in cfg.
+func NewWithConfig(filename string, cfg Config) (*Ledger, error) {
 	var l Ledger
+	if err := l.parseFile(filename, cfg); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// parseHeaderMeta adds the key and value of a "; key: value" header comment
+// to the ledger's HeaderMeta. Other header comments are ignored.
+func (l *Ledger) parseHeaderMeta(line string) {
+	key, value, ok := strings.Cut(strings.TrimPrefix(line, ";"), ":")
+	if !ok {
+		return
+	}
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return
+	}
+	l.HeaderMeta[key] = strings.TrimSpace(value)
+}
+
+// utf8BOM is the UTF-8 encoded byte order mark.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// parseFile parses the ledger in filename into l using the options in cfg.
+func (l *Ledger) parseFile(filename string, cfg Config) error {
+	if cfg.Precision != nil && *cfg.Precision < 0 {
+		return fmt.Errorf("ledger: negative precision: %d", *cfg.Precision)
+	}
+	l.Filename = filename
+	l.includes = []string{filepath.Clean(filename)}
 	l.Commodities = make(map[string]bool)
+	l.CommodityPrecision = make(map[string]int)
 	l.Accounts = make(map[string]bool)
 	l.Tags = make(map[string]bool)
-	if err := l.parseNoMetadataFile(noMetadataFilename); err != nil {
-		return nil, err
+	l.HeaderMeta = make(map[string]string)
+	l.Contents = cfg.Contents
+	l.config = cfg
+	if err := l.parseNoMetadataFile(cfg.NoMetadataFilename); err != nil {
+		return err
 	}
-	fp, err := os.Open(filename)
+	b, err := file.Read(filename)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// files written by Windows tools can start with a byte order mark, CRLF
+	// line endings are handled by bufio.ScanLines
+	b = bytes.TrimPrefix(b, utf8BOM)
+	if cfg.Strict {
+		// collect declarations first, so entries can use commodities,
+		// accounts, and tags declared further down in the file
+		l.scanDeclarations(b)
 	}
-	defer fp.Close()
-	state := parseHeaderComments
-	scanner := bufio.NewScanner(fp)
-	ln := 0
+	if err := l.parse(bytes.NewReader(b), parseHeaderComments); err != nil {
+		return err
+	}
+	if !cfg.DeferAssertions {
+		if err := l.ValidateAssertions(); err != nil {
+			return err
+		}
+	}
+	if cfg.DeferMetadata {
+		return nil
+	}
+	return l.validateMetadata(cfg.Strict)
+}
+
+// parseDeclaration adds the commodity, account, or tag declared in line to
+// the ledger. It returns false, if line is not a declaration.
+func (l *Ledger) parseDeclaration(line string) bool {
+	switch {
+	case strings.HasPrefix(line, "commodity "):
+		l.Commodities[strings.TrimPrefix(line, "commodity ")] = true
+	case strings.HasPrefix(line, "account "):
+		l.Accounts[strings.TrimPrefix(line, "account ")] = true
+	case strings.HasPrefix(line, "tag "):
+		l.Tags[strings.TrimPrefix(line, "tag ")] = true
+	default:
+		return false
+	}
+	return true
+}
+
+// scanDeclarations adds all commodity, account, and tag declarations in b
+// to the ledger, regardless of their position.
+func (l *Ledger) scanDeclarations(b []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		l.parseDeclaration(scanner.Text())
+	}
+}
+
+// parse parses the lines read from r, starting in the given parser state.
+// Line numbers continue after the lines parsed so far and parsed entries
+// must not be dated before the last entry already in the ledger.
+func (l *Ledger) parse(r io.Reader, state int) error {
+	scanner := bufio.NewScanner(r)
+	ln := l.lines
 	previousDate := time.Unix(0, 0)
+	if len(l.Entries) > 0 {
+		previousDate = l.Entries[len(l.Entries)-1].currentDate()
+	}
+	applied := l.applied // stack of account prefixes from apply account
+	var commodity string // last commodity declared, for the format subdirective
 	for scanner.Scan() {
 		line := scanner.Text()
 		ln++
@@ -570,6 +1138,7 @@ func New(
 		if state == parseHeaderComments {
 			if strings.HasPrefix(line, ";") {
 				l.HeaderComments = append(l.HeaderComments, line)
+				l.parseHeaderMeta(line)
 				continue
 			} else {
 				state = parseCommodities
@@ -577,7 +1146,14 @@ func New(
 		}
 		if state == parseCommodities {
 			if strings.HasPrefix(line, "commodity ") {
-				l.Commodities[strings.TrimPrefix(line, "commodity ")] = true
+				commodity = strings.TrimPrefix(line, "commodity ")
+				l.Commodities[commodity] = true
+				continue
+			} else if commodity != "" &&
+				(strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+				if err := l.parseCommodityFormat(commodity, line, ln); err != nil {
+					return err
+				}
 				continue
 			} else {
 				state = parseAccounts
@@ -605,29 +1181,308 @@ func New(
 				warning(fmt.Sprintf("line %d: skipping comment", ln))
 				continue
 			}
-			e, err := parseEntry(scanner, line, &ln, &previousDate, strict,
-				addMissingHashes, l.Commodities, l.Accounts, l.NoMetadata)
+			// declarations after entries
+			if commodity != "" &&
+				(strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+				if err := l.parseCommodityFormat(commodity, line, ln); err != nil {
+					return err
+				}
+				continue
+			}
+			commodity = ""
+			if strings.HasPrefix(line, "commodity ") {
+				commodity = strings.TrimPrefix(line, "commodity ")
+			}
+			if l.parseDeclaration(line) {
+				continue
+			}
+			if strings.HasPrefix(line, "D ") {
+				if err := l.parseDefaultCommodity(line, ln); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasPrefix(line, "include ") {
+				path := strings.TrimSpace(strings.TrimPrefix(line, "include "))
+				if err := l.include(path, ln); err != nil {
+					return err
+				}
+				// entries after the include must not predate the included ones
+				if len(l.Entries) > 0 {
+					previousDate = l.Entries[len(l.Entries)-1].currentDate()
+				}
+				continue
+			}
+			if strings.HasPrefix(line, "apply account ") {
+				applied = append(applied,
+					strings.TrimSpace(strings.TrimPrefix(line, "apply account ")))
+				continue
+			}
+			if line == "end apply account" || line == "end apply" {
+				if len(applied) == 0 {
+					return fmt.Errorf("ledger: line %d: %s without apply account", ln, line)
+				}
+				applied = applied[:len(applied)-1]
+				continue
+			}
+			e, err := parseEntry(scanner, line, &ln, &previousDate, l.config,
+				l.Commodities, l.Accounts, l.NoMetadata, strings.Join(applied, ":"),
+				l.DefaultCommodity, l.CommodityPrecision)
 			if err != nil {
-				return nil, err
+				return err
+			}
+			if len(l.includes) > 1 {
+				e.file = l.includes[len(l.includes)-1]
 			}
 			l.Entries = append(l.Entries, *e)
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return err
 	}
+	l.lines = ln
+	l.applied = applied
+	return nil
+}
 
-	if err := l.validateMetadata(strict); err != nil {
-		return nil, err
+// Reparse re-reads the ledger from its file using the options in cfg and
+// replaces the content of l. If parsing fails, l is left unchanged.
+func (l *Ledger) Reparse(cfg Config) error {
+	var n Ledger
+	if err := n.parseFile(l.Filename, cfg); err != nil {
+		return err
 	}
+	*l = n
+	return nil
+}
 
-	return &l, nil
+// parseCommodityFormat parses the subdirective in line ln following the
+// declaration of commodity. Only "format Amount Commodity" is supported, the
+// number of decimal places of the amount sets the precision commodity is
+// printed with (e.g. "format 1,00000000 BTC").
+func (l *Ledger) parseCommodityFormat(commodity, line string, ln int) error {
+	elems := strings.Fields(line)
+	if len(elems) == 0 || elems[0] != "format" {
+		return fmt.Errorf("ledger: line %d: unknown commodity subdirective: %s", ln,
+			strings.TrimSpace(line))
+	}
+	if len(elems) != 3 || elems[2] != commodity {
+		return fmt.Errorf("ledger: line %d: invalid commodity format (expected format Amount %s)",
+			ln, commodity)
+	}
+	amount := elems[1]
+	for _, r := range amount {
+		if (r < '0' || r > '9') && r != '.' && r != ',' && r != '-' {
+			return fmt.Errorf("ledger: line %d: invalid commodity format amount: %s", ln, amount)
+		}
+	}
+	// the last separator is the decimal mark
+	precision := 0
+	if i := strings.LastIndexAny(amount, ".,"); i >= 0 {
+		precision = len(amount) - i - 1
+	}
+	if l.CommodityPrecision == nil {
+		l.CommodityPrecision = make(map[string]int)
+	}
+	l.CommodityPrecision[commodity] = precision
+	return nil
+}
+
+// parseDefaultCommodity sets the DefaultCommodity from a directive like
+// "D 1.000,00 EUR" in line ln. The amount only shows the format in Ledger and
+// is ignored. In strict mode the commodity must be declared.
+func (l *Ledger) parseDefaultCommodity(line string, ln int) error {
+	elems := strings.Fields(line)
+	if len(elems) != 3 {
+		return fmt.Errorf("ledger: line %d: invalid default commodity format (expected D Amount Commodity)", ln)
+	}
+	commodity := elems[2]
+	if canonical, ok := l.config.CommodityAliases[commodity]; ok {
+		commodity = canonical
+	}
+	if l.config.Strict && !l.Commodities[commodity] {
+		return fmt.Errorf("ledger: line %d: default commodity unknown: %s", ln, commodity)
+	}
+	l.DefaultCommodity = commodity
+	return nil
+}
+
+// include parses the file included by an "include path" directive in line
+// ln into l. A relative path is resolved against the directory of the
+// including file. The declarations and entries of the included file are
+// added to l, its line numbers start at 1 and errors are prefixed with its
+// filename. Circular includes are rejected.
+func (l *Ledger) include(path string, ln int) error {
+	parent := l.Filename
+	if len(l.includes) > 0 {
+		parent = l.includes[len(l.includes)-1]
+	}
+	filename := path
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(parent), path)
+	}
+	filename = filepath.Clean(filename)
+	for _, f := range l.includes {
+		if f == filename {
+			return fmt.Errorf("ledger: line %d: circular include: %s includes %s",
+				ln, parent, filename)
+		}
+	}
+	b, err := file.Read(filename)
+	if err != nil {
+		return fmt.Errorf("ledger: line %d: %s", ln, err)
+	}
+	b = bytes.TrimPrefix(b, utf8BOM)
+	if l.config.Strict {
+		l.scanDeclarations(b)
+	}
+	lines, applied := l.lines, l.applied
+	l.lines, l.applied = 0, nil
+	l.includes = append(l.includes, filename)
+	err = l.parse(bytes.NewReader(b), parseHeaderComments)
+	l.includes = l.includes[:len(l.includes)-1]
+	l.lines, l.applied = lines, applied
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+// AppendFrom parses the text read from r as if it followed the ledger file
+// and appends the parsed entries to the ledger, using the options the ledger
+// was created with. This is a fast path for files which only grew at the
+// end: r can contain everything allowed after the first entry (entries,
+// late declarations, D, include, and apply account directives) and entries
+// must not be dated before the last entry already in the ledger. An apply
+// account still open at the end of the ledger file applies to r as well.
+// Line numbers continue after the last line parsed so far. If parsing or
+// validation fails, l is left unchanged.
+func (l *Ledger) AppendFrom(r io.Reader) error {
+	// parse into a copy, declarations and directives between the entries
+	// can change the maps and the default commodity
+	n := *l
+	n.Commodities = maps.Clone(l.Commodities)
+	n.CommodityPrecision = maps.Clone(l.CommodityPrecision)
+	n.Accounts = maps.Clone(l.Accounts)
+	n.Tags = maps.Clone(l.Tags)
+	n.HeaderMeta = maps.Clone(l.HeaderMeta)
+	n.Entries = slices.Clip(l.Entries)
+	n.includes = slices.Clone(l.includes)
+	n.applied = slices.Clone(l.applied)
+	if err := n.parse(r, parseEntries); err != nil {
+		return err
+	}
+	if !n.config.DeferAssertions {
+		if err := n.ValidateAssertions(); err != nil {
+			return err
+		}
+	}
+	if !n.config.DeferMetadata {
+		if err := n.validateMetadata(n.config.Strict); err != nil {
+			return err
+		}
+	}
+	*l = n
+	return nil
 }
 
-func validateSubtree(seenFiles map[string]bool) error {
+// Merge merges the declarations and entries of other into l. Entries are
+// merged in date order, entries of l come first for the same date. If l was
+// parsed in strict mode, both ledgers must declare the same commodities and
+// accounts, otherwise the declarations are combined.
+func (l *Ledger) Merge(other *Ledger) error {
+	if l.config.Strict {
+		if err := compareDeclarations("commodity", l.Filename, l.Commodities,
+			other.Filename, other.Commodities); err != nil {
+			return err
+		}
+		if err := compareDeclarations("account", l.Filename, l.Accounts,
+			other.Filename, other.Accounts); err != nil {
+			return err
+		}
+	}
+	l.HeaderComments = append(l.HeaderComments, other.HeaderComments...)
+	if l.HeaderMeta == nil {
+		l.HeaderMeta = make(map[string]string)
+	}
+	for key, value := range other.HeaderMeta {
+		if _, ok := l.HeaderMeta[key]; !ok {
+			l.HeaderMeta[key] = value
+		}
+	}
+	for c := range other.Commodities {
+		l.Commodities[c] = true
+	}
+	for c, p := range other.CommodityPrecision {
+		if _, ok := l.CommodityPrecision[c]; !ok {
+			if l.CommodityPrecision == nil {
+				l.CommodityPrecision = make(map[string]int)
+			}
+			l.CommodityPrecision[c] = p
+		}
+	}
+	for a := range other.Accounts {
+		l.Accounts[a] = true
+	}
+	for t := range other.Tags {
+		l.Tags[t] = true
+	}
+	entries := make([]LedgerEntry, 0, len(l.Entries)+len(other.Entries))
+	entries = append(entries, l.Entries...)
+	for _, e := range other.Entries {
+		if e.file == "" {
+			e.file = other.Filename
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].currentDate().Before(entries[j].currentDate())
+	})
+	l.Entries = entries
+	return nil
+}
+
+// compareDeclarations returns an error if the declarations of kind in a and
+// b differ.
+func compareDeclarations(
+	kind string,
+	aFilename string,
+	a map[string]bool,
+	bFilename string,
+	b map[string]bool,
+) error {
+	var missing []string
+	for name := range a {
+		if !b[name] {
+			missing = append(missing, fmt.Sprintf("%s %s declared in %s but not in %s",
+				kind, name, aFilename, bFilename))
+		}
+	}
+	for name := range b {
+		if !a[name] {
+			missing = append(missing, fmt.Sprintf("%s %s declared in %s but not in %s",
+				kind, name, bFilename, aFilename))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("ledger: inconsistent declarations: %s",
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateSubtree makes sure every PDF file in the invoice subtree is in
+// seenFiles and every file in seenFiles exists. Files provided in contents
+// are kept in memory and need not exist in the filesystem; if contents is
+// non-empty a missing invoice subtree is not an error.
+func validateSubtree(seenFiles map[string]bool, contents map[string][]byte) error {
 	// Traverse the invoice subtree
 	err := filepath.Walk(invoiceSubtree, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if path == invoiceSubtree && os.IsNotExist(err) && len(contents) > 0 {
+				return filepath.SkipDir
+			}
 			return err
 		}
 
@@ -659,12 +1514,24 @@ func validateSubtree(seenFiles map[string]bool) error {
 
 	// Check if there are any files in the ledger that don't exist in the filesystem
 	for file := range seenFiles {
+		if _, ok := contents[file]; ok {
+			continue
+		}
 		return fmt.Errorf("file referenced in ledger but not found in filesystem: %s", file)
 	}
 
 	return nil
 }
 
+// ValidateMetadata checks the file metadata of all entries, if l was parsed
+// in strict mode: no file is referenced twice, no two files have the same
+// hash, and the PDF files in the invoice subtree match the referenced
+// files. It is called when a ledger is parsed, unless Config.DeferMetadata
+// is set.
+func (l *Ledger) ValidateMetadata() error {
+	return l.validateMetadata(l.config.Strict)
+}
+
 func (l *Ledger) validateMetadata(strict bool) error {
 	// only validate metadata in strict mode
 	if !strict {
@@ -694,7 +1561,7 @@ func (l *Ledger) validateMetadata(strict bool) error {
 		hash, ok := entry.Metadata["sha256"]
 		if !ok {
 			var err error
-			hash, err = file.SHA256Sum(entry.Metadata["file"])
+			hash, err = sha256Sum(entry.Metadata["file"], l.Contents)
 			if err != nil {
 				return fmt.Errorf("ledger: failed to calculate SHA256 hash for file '%s': %v",
 					entry.Metadata["file"], err)
@@ -720,7 +1587,7 @@ func (l *Ledger) validateMetadata(strict bool) error {
 		hash, ok = entry.Metadata["sha256Two"]
 		if !ok {
 			var err error
-			hash, err = file.SHA256Sum(entry.Metadata["fileTwo"])
+			hash, err = sha256Sum(entry.Metadata["fileTwo"], l.Contents)
 			if err != nil {
 				return fmt.Errorf("ledger: failed to calculate SHA256 hash for file '%s': %v",
 					entry.Metadata["fileTwo"], err)
@@ -734,20 +1601,199 @@ func (l *Ledger) validateMetadata(strict bool) error {
 	}
 
 	// make sure every PDF file in the invoice subtree is referenced at least once
-	if err := validateSubtree(seenFiles); err != nil {
+	if err := validateSubtree(seenFiles, l.Contents); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// now returns the current time, it is a variable to allow tests to fix it.
+var now = time.Now
+
+// EntriesAsOf returns the entries dated on or before date, using the
+// effective dates where present. The returned slice shares the entries with
+// the ledger.
+func (l *Ledger) EntriesAsOf(date time.Time) []LedgerEntry {
+	// entries are validated to be in ascending order
+	n := sort.Search(len(l.Entries), func(i int) bool {
+		return l.Entries[i].currentDate().After(date)
+	})
+	return l.Entries[:n]
+}
+
+// FilterOptions select entries in Filter. Zero values match all entries.
+type FilterOptions struct {
+	AccountPrefix string    // an account name must start with this prefix
+	StartDate     time.Time // entries dated before are excluded
+	EndDate       time.Time // entries dated on or after are excluded
+	Commodity     string    // an account must be posted in this commodity
+}
+
+// Filter returns the entries matching opts in ledger order. Entries are
+// dated by their effective date where present. The entries are copied into
+// a new slice, the ledger is not modified.
+func (l *Ledger) Filter(opts FilterOptions) []LedgerEntry {
+	var entries []LedgerEntry
+	for _, e := range l.Entries {
+		date := e.currentDate()
+		if !opts.StartDate.IsZero() && date.Before(opts.StartDate) {
+			continue
+		}
+		if !opts.EndDate.IsZero() && !date.Before(opts.EndDate) {
+			continue
+		}
+		account := opts.AccountPrefix == ""
+		commodity := opts.Commodity == ""
+		for _, a := range e.Accounts {
+			if strings.HasPrefix(a.Name, opts.AccountPrefix) {
+				account = true
+			}
+			if a.Commodity == opts.Commodity {
+				commodity = true
+			}
+		}
+		if account && commodity {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// reportEntries returns the entries reports are computed from. These are
+// all entries, unless the ledger was parsed with Config.IgnoreFuture.
+func (l *Ledger) reportEntries() []LedgerEntry {
+	if l.config.IgnoreFuture {
+		return l.EntriesAsOf(now())
+	}
+	return l.Entries
+}
+
+// DateRange returns the dates of the first and the last entry in the
+// ledger, using the effective dates where present. If the ledger has no
+// entries, ok is false.
+func (l *Ledger) DateRange() (first, last time.Time, ok bool) {
+	if len(l.Entries) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	// entries are validated to be in ascending order
+	first = l.Entries[0].currentDate()
+	last = l.Entries[len(l.Entries)-1].currentDate()
+	return first, last, true
+}
+
+// MissingAttachment is an entry which requires file metadata, but has none.
+type MissingAttachment struct {
+	LineNumber int
+	Date       time.Time
+	Payee      string
+	Accounts   []string
+}
+
+// EntriesMissingAttachments returns the entries the parser warns about
+// because their file metadata is missing, honoring NoMetadata.
+func (l *Ledger) EntriesMissingAttachments() []MissingAttachment {
+	var missing []MissingAttachment
+	for i := range l.Entries {
+		e := &l.Entries[i]
+		if !e.needsAttachment(l.NoMetadata) {
+			continue
+		}
+		m := MissingAttachment{
+			LineNumber: e.LineNumber,
+			Date:       e.Date,
+			Payee:      e.Name,
+		}
+		for _, a := range e.Accounts {
+			m.Accounts = append(m.Accounts, a.Name)
+		}
+		missing = append(missing, m)
+	}
+	return missing
+}
+
+// StaleDuplicateFlags returns the line numbers of entries marked with
+// "duplicate: true" whose files and hashes don't collide with any entry not
+// marked as duplicate. Such flags are likely leftovers. Only hashes recorded
+// in the metadata are compared, no files are read.
+func (l *Ledger) StaleDuplicateFlags() []int {
+	seenFiles := make(map[string]bool)
+	seenHashes := make(map[string]bool)
+	for _, entry := range l.Entries {
+		if entry.Metadata["duplicate"] == "true" {
+			continue
+		}
+		for _, key := range []string{"file", "fileTwo"} {
+			if entry.Metadata[key] != "" {
+				seenFiles[entry.Metadata[key]] = true
+			}
+		}
+		for _, key := range []string{"sha256", "sha256Two"} {
+			if entry.Metadata[key] != "" {
+				seenHashes[entry.Metadata[key]] = true
+			}
+		}
+	}
+
+	var lines []int
+	for _, entry := range l.Entries {
+		if entry.Metadata["duplicate"] != "true" {
+			continue
+		}
+		collides := false
+		for _, key := range []string{"file", "fileTwo"} {
+			if seenFiles[entry.Metadata[key]] {
+				collides = true
+			}
+		}
+		for _, key := range []string{"sha256", "sha256Two"} {
+			if seenHashes[entry.Metadata[key]] {
+				collides = true
+			}
+		}
+		if !collides {
+			lines = append(lines, entry.LineNumber)
+		}
+	}
+	return lines
+}
+
+// precision returns the number of decimal places amounts in commodity are
+// printed with: the precision from the commodity's format subdirective, if
+// any, and Config.Precision otherwise.
+func (l *Ledger) precision(commodity string) int {
+	if p, ok := l.CommodityPrecision[commodity]; ok {
+		return p
+	}
+	if l.config.Precision != nil {
+		return *l.config.Precision
+	}
+	return DefaultPrecision
+}
+
+// formatExact formats amount with the precision of commodity without losing
+// digits (see formatAmountExact).
+func (l *Ledger) formatExact(amount float64, commodity string) string {
+	return formatAmountExact(amount, l.precision(commodity))
+}
+
 // Print outputs the entire Ledger to stdout.
 func (l *Ledger) Print() {
+	l.WriteTo(os.Stdout)
+}
+
+// WriteTo writes the entire Ledger in ledger format to w and returns the
+// number of bytes written. Parsing the output again yields an equivalent
+// Ledger: amounts are written with the precision of their commodity, or with
+// as many decimal places as they need, if that is not enough. Posting notes
+// are written, so the output only parses with Config.PostingNotes then.
+func (l *Ledger) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
 	if len(l.HeaderComments) > 0 {
 		for _, line := range l.HeaderComments {
-			fmt.Println(line)
+			cw.println(line)
 		}
-		fmt.Println()
+		cw.println()
 	}
 	if len(l.Commodities) > 0 {
 		var commodities []string
@@ -756,9 +1802,16 @@ func (l *Ledger) Print() {
 		}
 		sort.Strings(commodities)
 		for _, c := range commodities {
-			fmt.Printf("commodity %s\n", c)
+			cw.printf("commodity %s\n", c)
+			if p, ok := l.CommodityPrecision[c]; ok {
+				cw.printf("  format %s %s\n", formatAmount(1, p), c)
+			}
 		}
-		fmt.Println()
+		cw.println()
+	}
+	if l.DefaultCommodity != "" {
+		cw.printf("D %s %s\n", formatAmount(1000, l.precision(l.DefaultCommodity)), l.DefaultCommodity)
+		cw.println()
 	}
 	if len(l.Accounts) > 0 {
 		var accounts []string
@@ -767,9 +1820,9 @@ func (l *Ledger) Print() {
 		}
 		sort.Strings(accounts)
 		for _, a := range accounts {
-			fmt.Printf("account %s\n", a)
+			cw.printf("account %s\n", a)
 		}
-		fmt.Println()
+		cw.println()
 	}
 	if len(l.Tags) > 0 {
 		var tags []string
@@ -778,14 +1831,15 @@ func (l *Ledger) Print() {
 		}
 		sort.Strings(tags)
 		for _, t := range tags {
-			fmt.Printf("tag %s\n", t)
+			cw.printf("tag %s\n", t)
 		}
-		fmt.Println()
+		cw.println()
 	}
 	for i, entry := range l.Entries {
 		if i > 0 {
-			fmt.Println()
+			cw.println()
 		}
-		entry.Print()
+		entry.write(cw, l.formatExact)
 	}
+	return cw.n, cw.err
 }
@@ -1,8 +1,13 @@
 package ledger
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -179,11 +184,86 @@ func TestParseAccount(t *testing.T) {
 			wantErr:     true,
 			errContains: "invalid account format",
 		},
+		{
+			name:       "tab separated account and amount",
+			line:       "Expenses:Food\t50,00 EUR",
+			ln:         1,
+			strict:     false,
+			wantName:   "Expenses:Food",
+			wantAmount: 50.0,
+			wantComm:   "EUR",
+			wantErr:    false,
+		},
+		{
+			name:       "amount in scientific notation",
+			line:       "Assets:Bitcoin  5e-08 BTC",
+			ln:         1,
+			strict:     false,
+			wantName:   "Assets:Bitcoin",
+			wantAmount: 5e-08,
+			wantComm:   "BTC",
+			wantErr:    false,
+		},
+		{
+			name:       "negative amount in scientific notation with comma",
+			line:       "Assets:Bitcoin  -1,5E-8 BTC",
+			ln:         1,
+			strict:     false,
+			wantName:   "Assets:Bitcoin",
+			wantAmount: -1.5e-08,
+			wantComm:   "BTC",
+			wantErr:    false,
+		},
+		{
+			name:          "price in scientific notation",
+			line:          "Assets:Bitcoin  1e-3 BTC @ 4,2e4 EUR",
+			ln:            1,
+			strict:        false,
+			wantName:      "Assets:Bitcoin",
+			wantAmount:    0.001,
+			wantComm:      "BTC",
+			wantPriceType: "@",
+			wantPriceAmt:  42000,
+			wantPriceComm: "EUR",
+			wantErr:       false,
+		},
+		{
+			name:        "NaN amount",
+			line:        "Assets:Bank  NaN EUR",
+			ln:          1,
+			strict:      false,
+			wantErr:     true,
+			errContains: "invalid syntax",
+		},
+		{
+			name:        "hexadecimal price amount",
+			line:        "Assets:Bitcoin  1 BTC @ 0x1p4 EUR",
+			ln:          1,
+			strict:      false,
+			wantErr:     true,
+			errContains: "invalid price amount",
+		},
+		{
+			name:        "invalid balance assertion amount",
+			line:        "Assets:Bank  -50,00 EUR = abc EUR",
+			ln:          4,
+			strict:      false,
+			wantErr:     true,
+			errContains: "line 4: invalid balance assertion",
+		},
+		{
+			name:        "balance assertion with unknown commodity in strict mode",
+			line:        "Assets:Bank  -50,00 EUR = 950,00 XYZ",
+			ln:          1,
+			strict:      true,
+			wantErr:     true,
+			errContains: "assertion commodity unknown: XYZ",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseAccount(tt.line, tt.ln, tt.strict, commodities, accounts)
+			got, err := parseAccount(tt.line, tt.ln, tt.strict, commodities, accounts, nil, "")
 
 			if tt.wantErr {
 				if err == nil {
@@ -1311,391 +1391,585 @@ account Equity:Opening
 			t.Errorf("Elided account Amount = %v, want 0", elidedAccount.Amount)
 		}
 	})
-}
 
-func TestProcFilename(t *testing.T) {
-	t.Run("file exists and is PDF", func(t *testing.T) {
+	t.Run("entry line range", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
-		}
+		ledgerFile := filepath.Join(dir, "test.ledger")
 
-		if err := procFilename(file1); err != nil {
-			t.Errorf("procFilename() error = %v, want nil", err)
-		}
-	})
+		content := `commodity EUR
 
-	t.Run("file does not exist", func(t *testing.T) {
-		err := procFilename("/nonexistent/path/invoice.pdf")
-		if err == nil {
-			t.Fatal("procFilename() expected error for nonexistent file, got nil")
-		}
-		if !contains(err.Error(), "doesn't exist") {
-			t.Errorf("error should mention file doesn't exist, got: %v", err)
-		}
-	})
+account Assets:Bank
+account Expenses:Food
 
-	t.Run("file exists but not PDF", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "document.txt")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+    ; note: weekly
+
+2024/01/15 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		err := procFilename(file1)
-		if err == nil {
-			t.Fatal("procFilename() expected error for non-PDF file, got nil")
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
 		}
-		if !contains(err.Error(), "not a PDF") {
-			t.Errorf("error should mention not a PDF, got: %v", err)
+
+		if len(l.Entries) != 2 {
+			t.Fatalf("Entries len = %d, want 2", len(l.Entries))
+		}
+		if l.Entries[0].LineNumber != 6 || l.Entries[0].EndLineNumber != 9 {
+			t.Errorf("first entry lines = %d-%d, want 6-9",
+				l.Entries[0].LineNumber, l.Entries[0].EndLineNumber)
+		}
+		if l.Entries[1].LineNumber != 11 || l.Entries[1].EndLineNumber != 13 {
+			t.Errorf("second entry lines = %d-%d, want 11-13",
+				l.Entries[1].LineNumber, l.Entries[1].EndLineNumber)
 		}
 	})
-}
 
-func TestProcHash(t *testing.T) {
-	t.Run("hash exists strict mode matches", func(t *testing.T) {
+	t.Run("postings indented with tabs", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		content := []byte("test content for hashing")
-		if err := os.WriteFile(file1, content, 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := "commodity EUR\n\n" +
+			"account Assets:Bank\n" +
+			"account Expenses:Food\n\n" +
+			"2024/01/01 Grocery store\n" +
+			"\tExpenses:Food\t50,00 EUR\n" +
+			"\tAssets:Bank\n" +
+			"\t; note: tabs\n"
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		// Calculate the actual hash using the same function
-		actualHash, err := file.SHA256Sum(file1)
+		l, err := New(ledgerFile, false, false, "")
 		if err != nil {
-			t.Fatalf("failed to calculate hash: %v", err)
+			t.Fatalf("New() error: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"sha256": actualHash,
-			},
+		if len(l.Entries) != 1 {
+			t.Fatalf("Entries len = %d, want 1", len(l.Entries))
 		}
-
-		err = e.procHash("sha256", file1, true, false, 1)
-		if err != nil {
-			t.Errorf("procHash() error = %v, want nil", err)
+		entry := l.Entries[0]
+		if len(entry.Accounts) != 2 {
+			t.Fatalf("Accounts len = %d, want 2", len(entry.Accounts))
+		}
+		if entry.Accounts[0].Name != "Expenses:Food" || entry.Accounts[0].Amount != 50.0 {
+			t.Errorf("first account = %s %v, want Expenses:Food 50",
+				entry.Accounts[0].Name, entry.Accounts[0].Amount)
+		}
+		if entry.Accounts[1].Amount != -50.0 {
+			t.Errorf("elided amount = %v, want -50", entry.Accounts[1].Amount)
+		}
+		if entry.Metadata["note"] != "tabs" {
+			t.Errorf("Metadata[note] = %q, want tabs", entry.Metadata["note"])
 		}
 	})
 
-	t.Run("hash exists strict mode mismatch", func(t *testing.T) {
+	t.Run("posting notes", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR  ; vegetables
+  Assets:Bank  ; paid by card
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"sha256": "wronghash",
-			},
+		// rejected by default
+		if _, err := New(ledgerFile, false, false, ""); err == nil {
+			t.Fatal("New() expected error for posting note, got nil")
 		}
 
-		err := e.procHash("sha256", file1, true, false, 5)
-		if err == nil {
-			t.Fatal("procHash() expected error for hash mismatch, got nil")
+		l, err := NewWithConfig(ledgerFile, Config{PostingNotes: true})
+		if err != nil {
+			t.Fatalf("NewWithConfig() error: %v", err)
 		}
-		if !contains(err.Error(), "hash mismatch") {
-			t.Errorf("error should mention hash mismatch, got: %v", err)
+		entry := l.Entries[0]
+		if entry.Accounts[0].Note != "vegetables" || entry.Accounts[0].Amount != 50 {
+			t.Errorf("first account = %v %q, want 50 with note vegetables",
+				entry.Accounts[0].Amount, entry.Accounts[0].Note)
 		}
-		if !contains(err.Error(), "line 5") {
-			t.Errorf("error should mention line number, got: %v", err)
+		if entry.Accounts[1].Note != "paid by card" || !entry.Accounts[1].Elided {
+			t.Errorf("second account = elided %v %q, want elided with note paid by card",
+				entry.Accounts[1].Elided, entry.Accounts[1].Note)
+		}
+		if entry.Accounts[1].Amount != -50 {
+			t.Errorf("elided amount = %v, want -50", entry.Accounts[1].Amount)
 		}
 	})
 
-	t.Run("hash exists non-strict mode skips verification", func(t *testing.T) {
+	t.Run("entry with payee and note", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Store | weekly groceries
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/02 Bakery
+  Expenses:Food  5,00 EUR
+  Assets:Bank
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"sha256": "wronghash", // wrong hash, but non-strict so should pass
-			},
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
 		}
 
-		err := e.procHash("sha256", file1, false, false, 1)
-		if err != nil {
-			t.Errorf("procHash() error = %v, want nil", err)
+		if l.Entries[0].Name != "Store" {
+			t.Errorf("Name = %q, want Store", l.Entries[0].Name)
+		}
+		if l.Entries[0].Note != "weekly groceries" {
+			t.Errorf("Note = %q, want weekly groceries", l.Entries[0].Note)
+		}
+		if l.Entries[1].Name != "Bakery" || l.Entries[1].Note != "" {
+			t.Errorf("second entry = %q %q, want Bakery without note",
+				l.Entries[1].Name, l.Entries[1].Note)
 		}
 	})
 
-	t.Run("hash missing with addMissingHashes", func(t *testing.T) {
+	t.Run("structured header comments", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `; title: Household
+; owner: Jane Doe
+; base-currency: EUR
+; This ledger tracks: everything
+
+commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{},
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
 		}
 
-		err := e.procHash("sha256", file1, false, true, 1)
-		if err != nil {
-			t.Errorf("procHash() error = %v, want nil", err)
+		if len(l.HeaderComments) != 4 {
+			t.Errorf("HeaderComments len = %d, want 4", len(l.HeaderComments))
 		}
-		if e.Metadata["sha256"] == "" {
-			t.Error("hash should have been added to metadata")
+		want := map[string]string{
+			"title":         "Household",
+			"owner":         "Jane Doe",
+			"base-currency": "EUR",
+		}
+		if len(l.HeaderMeta) != len(want) {
+			t.Errorf("HeaderMeta = %v, want %v", l.HeaderMeta, want)
+		}
+		for key, value := range want {
+			if l.HeaderMeta[key] != value {
+				t.Errorf("HeaderMeta[%s] = %q, want %q", key, l.HeaderMeta[key], value)
+			}
 		}
 	})
 
-	t.Run("hash missing strict mode no addMissingHashes", func(t *testing.T) {
+	t.Run("apply account directive", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank:Checking
+account Assets:Bank:Savings
+account Expenses:Food
+
+apply account Assets
+
+apply account Bank
+2024/01/01 Transfer
+  Savings  100,00 EUR
+  Checking
+
+end apply account
+
+2024/01/02 Cash withdrawal
+  Cash  50,00 EUR
+  Bank:Checking
+
+end apply account
+
+2024/01/03 Grocery store
+  Expenses:Food  25,00 EUR
+  Assets:Bank:Checking
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{},
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
 		}
 
-		err := e.procHash("sha256", file1, true, false, 1)
-		if err == nil {
-			t.Fatal("procHash() expected error for missing hash in strict mode, got nil")
+		if len(l.Entries) != 3 {
+			t.Fatalf("Entries len = %d, want 3", len(l.Entries))
 		}
-		if !contains(err.Error(), "no hash for file") {
-			t.Errorf("error should mention no hash, got: %v", err)
+		want := [][]string{
+			{"Assets:Bank:Savings", "Assets:Bank:Checking"},
+			{"Assets:Cash", "Assets:Bank:Checking"},
+			{"Expenses:Food", "Assets:Bank:Checking"},
+		}
+		for i, names := range want {
+			for j, name := range names {
+				if l.Entries[i].Accounts[j].Name != name {
+					t.Errorf("Entries[%d].Accounts[%d].Name = %s, want %s",
+						i, j, l.Entries[i].Accounts[j].Name, name)
+				}
+			}
 		}
 	})
 
-	t.Run("hash missing non-strict no addMissingHashes passes", func(t *testing.T) {
+	t.Run("end apply account without apply account", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+end apply account
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{},
+		_, err := New(ledgerFile, false, false, "")
+		if err == nil {
+			t.Fatal("New() expected error for unmatched end apply account, got nil")
 		}
-
-		err := e.procHash("sha256", file1, false, false, 1)
-		if err != nil {
-			t.Errorf("procHash() error = %v, want nil", err)
+		if !contains(err.Error(), "line 3") {
+			t.Errorf("error should mention line number, got: %v", err)
 		}
 	})
 
-	t.Run("error calculating hash for missing file", func(t *testing.T) {
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"sha256": "somehash",
-			},
+	t.Run("strict mode declarations after entries", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
 		}
+		defer os.RemoveAll("invoices")
 
-		err := e.procHash("sha256", "/nonexistent/file.pdf", true, false, 1)
-		if err == nil {
-			t.Fatal("procHash() expected error for missing file, got nil")
+		dir := t.TempDir()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/02 Exchange
+  Assets:Bank  -100,00 EUR
+  Assets:Dollar  110,00 USD
+
+commodity USD
+account Assets:Dollar
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l, err := New(ledgerFile, true, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if !l.Commodities["USD"] || !l.Accounts["Assets:Dollar"] {
+			t.Error("declarations after entries should be recognized")
+		}
+		if len(l.Entries) != 2 {
+			t.Errorf("Entries len = %d, want 2", len(l.Entries))
 		}
 	})
 
-	t.Run("error calculating hash when adding missing hash", func(t *testing.T) {
-		e := &LedgerEntry{
-			Metadata: map[string]string{},
+	t.Run("gzip compressed ledger and noMetadata files", func(t *testing.T) {
+		dir := t.TempDir()
+
+		writeGzip := func(filename, content string) {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := zw.Write([]byte(content)); err != nil {
+				t.Fatalf("failed to compress: %v", err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatalf("failed to compress: %v", err)
+			}
+			if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+				t.Fatalf("failed to write test file: %v", err)
+			}
 		}
 
-		err := e.procHash("sha256", "/nonexistent/file.pdf", false, true, 1)
-		if err == nil {
-			t.Fatal("procHash() expected error for missing file, got nil")
+		content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`
+		ledgerFile := filepath.Join(dir, "test.ledger.gz")
+		writeGzip(ledgerFile, content)
+		// detected by magic bytes without .gz extension
+		noMetadataFile := filepath.Join(dir, "no-metadata.conf")
+		writeGzip(noMetadataFile, "Expenses:Food\n")
+
+		l, err := New(ledgerFile, false, false, noMetadataFile)
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if len(l.Entries) != 1 || l.Entries[0].Accounts[0].Amount != 50 {
+			t.Errorf("Entries = %v, want one entry with 50 EUR", l.Entries)
+		}
+		if !l.NoMetadata["Expenses:Food"] {
+			t.Error("NoMetadata should contain Expenses:Food")
 		}
 	})
 
-	t.Run("sha256Two metadata key", func(t *testing.T) {
+	t.Run("corrupt gzip file", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		ledgerFile := filepath.Join(dir, "test.ledger.gz")
+		if err := os.WriteFile(ledgerFile, []byte("not compressed"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
+		if _, err := New(ledgerFile, false, false, ""); err == nil {
+			t.Fatal("New() expected error for corrupt gzip file, got nil")
+		}
+	})
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{},
+	t.Run("entry with date metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank
+account Assets:Broker
+
+2024/01/18 Buy shares
+  Assets:Broker  100,00 EUR
+  Assets:Bank
+    ; date: 2024/01/20
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		err := e.procHash("sha256Two", file1, false, true, 1)
+		l, err := New(ledgerFile, false, false, "")
 		if err != nil {
-			t.Errorf("procHash() error = %v, want nil", err)
+			t.Fatalf("New() error: %v", err)
 		}
-		if e.Metadata["sha256Two"] == "" {
-			t.Error("sha256Two should have been added to metadata")
+		if l.Entries[0].AuxDate.Format(DateFormat) != "2024/01/20" {
+			t.Errorf("AuxDate = %s, want 2024/01/20", l.Entries[0].AuxDate.Format(DateFormat))
 		}
 	})
-}
 
-func TestProcMetadata(t *testing.T) {
-	t.Run("nil metadata passes", func(t *testing.T) {
-		e := &LedgerEntry{
-			Metadata: nil,
-			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank"},
-				{Name: "Assets:Cash"},
-			},
+	t.Run("entry with invalid date metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+
+		content := `commodity EUR
+
+account Assets:Bank
+account Assets:Broker
+
+2024/01/18 Buy shares
+  Assets:Broker  100,00 EUR
+  Assets:Bank
+    ; date: 20.01.2024
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		err := e.procMetadata(false, false, 1, nil)
-		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+		_, err := New(ledgerFile, false, false, "")
+		if err == nil {
+			t.Fatal("New() expected error for invalid date metadata, got nil")
+		}
+		if !contains(err.Error(), "line 6: invalid date metadata") {
+			t.Errorf("error should mention invalid date metadata, got: %v", err)
 		}
 	})
+}
 
-	t.Run("valid file metadata", func(t *testing.T) {
+func TestProcFilename(t *testing.T) {
+	t.Run("file exists and is PDF", func(t *testing.T) {
 		dir := t.TempDir()
 		file1 := filepath.Join(dir, "invoice.pdf")
 		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file": file1,
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
-		}
-
-		err := e.procMetadata(false, false, 1, nil)
-		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+		if err := procFilename(file1, nil); err != nil {
+			t.Errorf("procFilename() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("invalid file metadata - file does not exist", func(t *testing.T) {
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file": "/nonexistent/invoice.pdf",
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
-		}
-
-		err := e.procMetadata(false, false, 1, nil)
+	t.Run("file does not exist", func(t *testing.T) {
+		err := procFilename("/nonexistent/path/invoice.pdf", nil)
 		if err == nil {
-			t.Fatal("procMetadata() expected error for nonexistent file, got nil")
+			t.Fatal("procFilename() expected error for nonexistent file, got nil")
 		}
 		if !contains(err.Error(), "doesn't exist") {
 			t.Errorf("error should mention file doesn't exist, got: %v", err)
 		}
 	})
 
-	t.Run("invalid file metadata - not a PDF", func(t *testing.T) {
+	t.Run("file exists but not PDF", func(t *testing.T) {
 		dir := t.TempDir()
 		file1 := filepath.Join(dir, "document.txt")
 		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file": file1,
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
+		err := procFilename(file1, nil)
+		if err == nil {
+			t.Fatal("procFilename() expected error for non-PDF file, got nil")
 		}
+		if !contains(err.Error(), "not a PDF") {
+			t.Errorf("error should mention not a PDF, got: %v", err)
+		}
+	})
 
-		err := e.procMetadata(false, false, 1, nil)
+	t.Run("file from in-memory contents", func(t *testing.T) {
+		contents := map[string][]byte{
+			"/upload/invoice.pdf": []byte("pdf"),
+			"/upload/invoice.txt": []byte("txt"),
+		}
+		if err := procFilename("/upload/invoice.pdf", contents); err != nil {
+			t.Errorf("procFilename() error = %v, want nil", err)
+		}
+		err := procFilename("/upload/invoice.txt", contents)
 		if err == nil {
-			t.Fatal("procMetadata() expected error for non-PDF file, got nil")
+			t.Fatal("procFilename() expected error for non-PDF file, got nil")
 		}
 		if !contains(err.Error(), "not a PDF") {
 			t.Errorf("error should mention not a PDF, got: %v", err)
 		}
 	})
+}
 
-	t.Run("fileTwo without file errors", func(t *testing.T) {
+func TestProcHash(t *testing.T) {
+	t.Run("hash exists strict mode matches", func(t *testing.T) {
 		dir := t.TempDir()
 		file1 := filepath.Join(dir, "invoice.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+		content := []byte("test content for hashing")
+		if err := os.WriteFile(file1, content, 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
+		// Calculate the actual hash using the same function
+		actualHash, err := file.SHA256Sum(file1)
+		if err != nil {
+			t.Fatalf("failed to calculate hash: %v", err)
+		}
+
 		e := &LedgerEntry{
 			Metadata: map[string]string{
-				"fileTwo": file1,
+				"sha256": actualHash,
 			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
+		}
+
+		err = e.procHash("sha256", file1, true, false, 1, nil)
+		if err != nil {
+			t.Errorf("procHash() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("hash exists strict mode mismatch", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"sha256": "wronghash",
 			},
 		}
 
-		err := e.procMetadata(false, false, 5, nil)
+		err := e.procHash("sha256", file1, true, false, 5, nil)
 		if err == nil {
-			t.Fatal("procMetadata() expected error for fileTwo without file, got nil")
+			t.Fatal("procHash() expected error for hash mismatch, got nil")
 		}
-		if !contains(err.Error(), "'fileTwo' defined but not 'file'") {
-			t.Errorf("error should mention fileTwo without file, got: %v", err)
+		if !contains(err.Error(), "hash mismatch") {
+			t.Errorf("error should mention hash mismatch, got: %v", err)
 		}
 		if !contains(err.Error(), "line 5") {
 			t.Errorf("error should mention line number, got: %v", err)
 		}
 	})
 
-	t.Run("valid file and fileTwo metadata", func(t *testing.T) {
+	t.Run("hash exists non-strict mode skips verification", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
-		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
 		e := &LedgerEntry{
 			Metadata: map[string]string{
-				"file":    file1,
-				"fileTwo": file2,
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
+				"sha256": "wronghash", // wrong hash, but non-strict so should pass
 			},
 		}
 
-		err := e.procMetadata(false, false, 1, nil)
+		err := e.procHash("sha256", file1, false, false, 1, nil)
 		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+			t.Errorf("procHash() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("invalid fileTwo - file does not exist", func(t *testing.T) {
+	t.Run("hash missing with addMissingHashes", func(t *testing.T) {
 		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
 		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file":    file1,
-				"fileTwo": "/nonexistent/invoice.pdf",
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
+			Metadata: map[string]string{},
 		}
 
-		err := e.procMetadata(false, false, 1, nil)
-		if err == nil {
-			t.Fatal("procMetadata() expected error for nonexistent fileTwo, got nil")
+		err := e.procHash("sha256", file1, false, true, 1, nil)
+		if err != nil {
+			t.Errorf("procHash() error = %v, want nil", err)
 		}
-		if !contains(err.Error(), "doesn't exist") {
-			t.Errorf("error should mention file doesn't exist, got: %v", err)
+		if e.Metadata["sha256"] == "" {
+			t.Error("hash should have been added to metadata")
 		}
 	})
 
-	t.Run("strict mode hash error propagates", func(t *testing.T) {
+	t.Run("hash missing strict mode no addMissingHashes", func(t *testing.T) {
 		dir := t.TempDir()
 		file1 := filepath.Join(dir, "invoice.pdf")
 		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
@@ -1703,26 +1977,19 @@ func TestProcMetadata(t *testing.T) {
 		}
 
 		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file": file1,
-				// no sha256 - strict mode should error
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
+			Metadata: map[string]string{},
 		}
 
-		err := e.procMetadata(true, false, 1, nil)
+		err := e.procHash("sha256", file1, true, false, 1, nil)
 		if err == nil {
-			t.Fatal("procMetadata() expected error for missing hash in strict mode, got nil")
+			t.Fatal("procHash() expected error for missing hash in strict mode, got nil")
 		}
 		if !contains(err.Error(), "no hash for file") {
 			t.Errorf("error should mention no hash, got: %v", err)
 		}
 	})
 
-	t.Run("addMissingHashes adds hash", func(t *testing.T) {
+	t.Run("hash missing non-strict no addMissingHashes passes", func(t *testing.T) {
 		dir := t.TempDir()
 		file1 := filepath.Join(dir, "invoice.pdf")
 		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
@@ -1730,857 +1997,2734 @@ func TestProcMetadata(t *testing.T) {
 		}
 
 		e := &LedgerEntry{
-			Metadata: map[string]string{
-				"file": file1,
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
+			Metadata: map[string]string{},
 		}
 
-		err := e.procMetadata(false, true, 1, nil)
+		err := e.procHash("sha256", file1, false, false, 1, nil)
 		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
-		}
-		if e.Metadata["sha256"] == "" {
-			t.Error("sha256 should have been added to metadata")
+			t.Errorf("procHash() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("fileTwo hash error propagates", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
-		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
-		}
-
-		// Get actual hash for file1 so it passes
-		hash1, _ := file.SHA256Sum(file1)
-
+	t.Run("error calculating hash for missing file", func(t *testing.T) {
 		e := &LedgerEntry{
 			Metadata: map[string]string{
-				"file":    file1,
-				"sha256":  hash1,
-				"fileTwo": file2,
-				// no sha256Two - strict mode should error
-			},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
+				"sha256": "somehash",
 			},
 		}
 
-		err := e.procMetadata(true, false, 1, nil)
+		err := e.procHash("sha256", "/nonexistent/file.pdf", true, false, 1, nil)
 		if err == nil {
-			t.Fatal("procMetadata() expected error for missing sha256Two in strict mode, got nil")
-		}
-		if !contains(err.Error(), "no hash for file") {
-			t.Errorf("error should mention no hash, got: %v", err)
+			t.Fatal("procHash() expected error for missing file, got nil")
 		}
 	})
 
-	t.Run("missing file metadata for Expenses warns but passes", func(t *testing.T) {
+	t.Run("error calculating hash when adding missing hash", func(t *testing.T) {
 		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Test Entry",
 			Metadata: map[string]string{},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
 		}
 
-		// Should pass (just logs warning)
-		err := e.procMetadata(false, false, 1, nil)
-		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+		err := e.procHash("sha256", "/nonexistent/file.pdf", false, true, 1, nil)
+		if err == nil {
+			t.Fatal("procHash() expected error for missing file, got nil")
 		}
 	})
 
-	t.Run("missing file metadata for Income warns but passes", func(t *testing.T) {
-		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Salary",
-			Metadata: map[string]string{},
-			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank"},
-				{Name: "Income:Salary"},
-			},
-		}
-
-		// Should pass (just logs warning)
-		err := e.procMetadata(false, false, 1, nil)
-		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+	t.Run("sha256Two metadata key", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
 		}
-	})
 
-	t.Run("missing file metadata but account in noMetadata skips warning", func(t *testing.T) {
 		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Test Entry",
 			Metadata: map[string]string{},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-				{Name: "Assets:Bank"},
-			},
 		}
 
-		noMetadata := map[string]bool{"Expenses:Food": true}
-		err := e.procMetadata(false, false, 1, noMetadata)
+		err := e.procHash("sha256Two", file1, false, true, 1, nil)
 		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+			t.Errorf("procHash() error = %v, want nil", err)
+		}
+		if e.Metadata["sha256Two"] == "" {
+			t.Error("sha256Two should have been added to metadata")
 		}
 	})
 
-	t.Run("missing file metadata for non-Expenses/Income no warning", func(t *testing.T) {
+	t.Run("hash from in-memory contents", func(t *testing.T) {
+		content := []byte("uploaded invoice")
+		contents := map[string][]byte{"/upload/invoice.pdf": content}
+
 		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Transfer",
-			Metadata: map[string]string{},
-			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank"},
-				{Name: "Assets:Cash"},
+			Metadata: map[string]string{
+				"sha256": file.SHA256Bytes(content),
 			},
 		}
 
-		err := e.procMetadata(false, false, 1, nil)
+		// file doesn't exist on disk, so the hash must come from memory
+		err := e.procHash("sha256", "/upload/invoice.pdf", true, false, 1, contents)
 		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+			t.Errorf("procHash() error = %v, want nil", err)
 		}
-	})
 
-	t.Run("entry with single Expenses account", func(t *testing.T) {
-		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Single expense",
-			Metadata: map[string]string{},
-			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food"},
-			},
+		e.Metadata["sha256"] = "wronghash"
+		err = e.procHash("sha256", "/upload/invoice.pdf", true, false, 1, contents)
+		if err == nil {
+			t.Fatal("procHash() expected error for hash mismatch, got nil")
 		}
+	})
 
-		// Should not panic with only one account
-		err := e.procMetadata(false, false, 1, nil)
+	t.Run("add missing hash from in-memory contents", func(t *testing.T) {
+		content := []byte("uploaded invoice")
+		contents := map[string][]byte{"/upload/invoice.pdf": content}
+
+		e := &LedgerEntry{Metadata: map[string]string{}}
+
+		err := e.procHash("sha256", "/upload/invoice.pdf", false, true, 1, contents)
 		if err != nil {
-			t.Errorf("procMetadata() error = %v, want nil", err)
+			t.Fatalf("procHash() error = %v, want nil", err)
+		}
+		if e.Metadata["sha256"] != file.SHA256Bytes(content) {
+			t.Errorf("sha256 = %s, want %s", e.Metadata["sha256"], file.SHA256Bytes(content))
 		}
 	})
+}
 
-	t.Run("entry with three accounts including Expenses", func(t *testing.T) {
+func TestProcMetadata(t *testing.T) {
+	t.Run("nil metadata passes", func(t *testing.T) {
 		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Multi-account expense",
-			Metadata: map[string]string{},
+			Metadata: nil,
 			Accounts: []LedgerAccount{
 				{Name: "Assets:Bank"},
 				{Name: "Assets:Cash"},
-				{Name: "Expenses:Food"},
 			},
 		}
 
-		// Should check all accounts for Expenses/Income, not just first two
-		err := e.procMetadata(false, false, 1, nil)
+		err := e.procMetadata(false, false, 1, nil, nil)
 		if err != nil {
 			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("entry with four accounts Income in third position", func(t *testing.T) {
+	t.Run("valid file metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
-			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-			Name:     "Complex income entry",
-			Metadata: map[string]string{},
+			Metadata: map[string]string{
+				"file": file1,
+			},
 			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food"},
 				{Name: "Assets:Bank"},
-				{Name: "Assets:Cash"},
-				{Name: "Income:Salary"},
-				{Name: "Liabilities:Tax"},
 			},
 		}
 
-		// Should check all accounts for Expenses/Income
-		err := e.procMetadata(false, false, 1, nil)
+		err := e.procMetadata(false, false, 1, nil, nil)
 		if err != nil {
 			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
-}
 
-func TestValidateBalance(t *testing.T) {
-	t.Run("balanced two-account entry", func(t *testing.T) {
+	t.Run("invalid file metadata - file does not exist", func(t *testing.T) {
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file": "/nonexistent/invoice.pdf",
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -50.0, Commodity: "EUR"},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err == nil {
+			t.Fatal("procMetadata() expected error for nonexistent file, got nil")
+		}
+		if !contains(err.Error(), "doesn't exist") {
+			t.Errorf("error should mention file doesn't exist, got: %v", err)
 		}
 	})
 
-	t.Run("balanced three-account entry", func(t *testing.T) {
+	t.Run("invalid file metadata - not a PDF", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "document.txt")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file": file1,
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 100.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -70.0, Commodity: "EUR"},
-				{Name: "Assets:Cash", Amount: -30.0, Commodity: "EUR"},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err == nil {
+			t.Fatal("procMetadata() expected error for non-PDF file, got nil")
+		}
+		if !contains(err.Error(), "not a PDF") {
+			t.Errorf("error should mention not a PDF, got: %v", err)
 		}
 	})
 
-	t.Run("unbalanced entry returns error", func(t *testing.T) {
+	t.Run("fileTwo without file errors", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"fileTwo": file1,
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -40.0, Commodity: "EUR"},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		err := e.validateBalance(5)
+
+		err := e.procMetadata(false, false, 5, nil, nil)
 		if err == nil {
-			t.Fatal("validateBalance() expected error, got nil")
+			t.Fatal("procMetadata() expected error for fileTwo without file, got nil")
 		}
-		if !contains(err.Error(), "not balanced") {
-			t.Errorf("error should mention not balanced, got: %v", err)
+		if !contains(err.Error(), "'fileTwo' defined but not 'file'") {
+			t.Errorf("error should mention fileTwo without file, got: %v", err)
 		}
 		if !contains(err.Error(), "line 5") {
 			t.Errorf("error should mention line number, got: %v", err)
 		}
-		if !contains(err.Error(), "EUR") {
-			t.Errorf("error should mention commodity, got: %v", err)
-		}
 	})
 
-	t.Run("elided amount is calculated", func(t *testing.T) {
+	t.Run("valid file and fileTwo metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file":    file1,
+				"fileTwo": file2,
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 80.0, Commodity: "EUR"},
-				{Name: "Expenses:Tips", Amount: 15.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: 0, Commodity: ""}, // elided
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
-		}
-		if e.Accounts[2].Amount != -95.0 {
-			t.Errorf("elided amount = %v, want -95.0", e.Accounts[2].Amount)
-		}
-		if e.Accounts[2].Commodity != "EUR" {
-			t.Errorf("elided commodity = %v, want EUR", e.Accounts[2].Commodity)
+
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("multiple elided amounts returns error", func(t *testing.T) {
+	t.Run("invalid fileTwo - file does not exist", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file":    file1,
+				"fileTwo": "/nonexistent/invoice.pdf",
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: 0, Commodity: ""},
-				{Name: "Assets:Cash", Amount: 0, Commodity: ""},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		err := e.validateBalance(3)
+
+		err := e.procMetadata(false, false, 1, nil, nil)
 		if err == nil {
-			t.Fatal("validateBalance() expected error, got nil")
+			t.Fatal("procMetadata() expected error for nonexistent fileTwo, got nil")
 		}
-		if !contains(err.Error(), "multiple accounts with elided amounts") {
-			t.Errorf("error should mention multiple elided amounts, got: %v", err)
+		if !contains(err.Error(), "doesn't exist") {
+			t.Errorf("error should mention file doesn't exist, got: %v", err)
 		}
 	})
 
-	t.Run("elided amount with no other amounts returns error", func(t *testing.T) {
+	t.Run("strict mode hash error propagates", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file": file1,
+				// no sha256 - strict mode should error
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank", Amount: 0, Commodity: ""},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		err := e.validateBalance(1)
+
+		err := e.procMetadata(true, false, 1, nil, nil)
 		if err == nil {
-			t.Fatal("validateBalance() expected error, got nil")
+			t.Fatal("procMetadata() expected error for missing hash in strict mode, got nil")
 		}
-		if !contains(err.Error(), "cannot infer elided amount without other amounts") {
-			t.Errorf("error should mention cannot infer, got: %v", err)
+		if !contains(err.Error(), "no hash for file") {
+			t.Errorf("error should mention no hash, got: %v", err)
 		}
 	})
 
-	t.Run("elided amount with multiple commodities allowed", func(t *testing.T) {
-		// Multi-commodity entries with elided amount are allowed (like opening balances)
-		// The elided account implicitly receives balancing amounts for each commodity
+	t.Run("addMissingHashes adds hash", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file": file1,
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank", Amount: 100.0, Commodity: "EUR"},
-				{Name: "Assets:USD", Amount: 110.0, Commodity: "USD"},
-				{Name: "Equity:Opening", Amount: 0, Commodity: ""}, // elided
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		err := e.validateBalance(1)
+
+		err := e.procMetadata(false, true, 1, nil, nil)
 		if err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
-		// Elided account should remain unchanged (no single commodity can be set)
-		if e.Accounts[2].Commodity != "" {
-			t.Errorf("elided Commodity = %v, want empty", e.Accounts[2].Commodity)
+		if e.Metadata["sha256"] == "" {
+			t.Error("sha256 should have been added to metadata")
 		}
 	})
 
-	t.Run("balanced entry with floating-point precision", func(t *testing.T) {
+	t.Run("fileTwo hash error propagates", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		// Get actual hash for file1 so it passes
+		hash1, _ := file.SHA256Sum(file1)
+
 		e := &LedgerEntry{
+			Metadata: map[string]string{
+				"file":    file1,
+				"sha256":  hash1,
+				"fileTwo": file2,
+				// no sha256Two - strict mode should error
+			},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 33.33, Commodity: "EUR"},
-				{Name: "Expenses:Tips", Amount: 33.33, Commodity: "EUR"},
-				{Name: "Expenses:Tax", Amount: 33.34, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -100.0, Commodity: "EUR"},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+
+		err := e.procMetadata(true, false, 1, nil, nil)
+		if err == nil {
+			t.Fatal("procMetadata() expected error for missing sha256Two in strict mode, got nil")
+		}
+		if !contains(err.Error(), "no hash for file") {
+			t.Errorf("error should mention no hash, got: %v", err)
 		}
 	})
 
-	t.Run("small imbalance within epsilon passes", func(t *testing.T) {
+	t.Run("missing file metadata for Expenses warns but passes", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Test Entry",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -50.004, Commodity: "EUR"}, // off by 0.004 < 0.005
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil (within epsilon)", err)
+
+		// Should pass (just logs warning)
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("imbalance exceeding epsilon fails", func(t *testing.T) {
+	t.Run("missing file metadata for Income warns but passes", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Salary",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: -50.01, Commodity: "EUR"}, // off by 0.01 > 0.005
+				{Name: "Assets:Bank"},
+				{Name: "Income:Salary"},
 			},
 		}
-		err := e.validateBalance(1)
-		if err == nil {
-			t.Fatal("validateBalance() expected error for imbalance exceeding epsilon")
+
+		// Should pass (just logs warning)
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("multiple commodities each balanced", func(t *testing.T) {
+	t.Run("missing file metadata but account in noMetadata skips warning", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Test Entry",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Assets:EUR", Amount: -100.0, Commodity: "EUR"},
-				{Name: "Assets:USD", Amount: 110.0, Commodity: "USD"},
-				{Name: "Expenses:Exchange", Amount: 100.0, Commodity: "EUR"},
-				{Name: "Expenses:Exchange", Amount: -110.0, Commodity: "USD"},
+				{Name: "Expenses:Food"},
+				{Name: "Assets:Bank"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+
+		noMetadata := map[string]bool{"Expenses:Food": true}
+		err := e.procMetadata(false, false, 1, noMetadata, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("multiple commodities no balance required", func(t *testing.T) {
-		// Multi-commodity entries don't require balancing (like currency exchange)
+	t.Run("missing file metadata for non-Expenses/Income no warning", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Transfer",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Assets:Cash", Amount: 160.0, Commodity: "USD"},
-				{Name: "Assets:Checkings", Amount: -130.36, Commodity: "EUR"},
+				{Name: "Assets:Bank"},
+				{Name: "Assets:Cash"},
 			},
 		}
-		err := e.validateBalance(1)
+
+		err := e.procMetadata(false, false, 1, nil, nil)
 		if err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("elided amount first in list", func(t *testing.T) {
+	t.Run("entry with single Expenses account", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Single expense",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Assets:Bank", Amount: 0, Commodity: ""}, // elided first
-				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
-				{Name: "Expenses:Tips", Amount: 10.0, Commodity: "EUR"},
+				{Name: "Expenses:Food"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
-		}
-		if e.Accounts[0].Amount != -60.0 {
-			t.Errorf("elided amount = %v, want -60.0", e.Accounts[0].Amount)
-		}
-		if e.Accounts[0].Commodity != "EUR" {
-			t.Errorf("elided commodity = %v, want EUR", e.Accounts[0].Commodity)
+
+		// Should not panic with only one account
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("negative amounts balance correctly", func(t *testing.T) {
+	t.Run("entry with three accounts including Expenses", func(t *testing.T) {
 		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Multi-account expense",
+			Metadata: map[string]string{},
 			Accounts: []LedgerAccount{
-				{Name: "Income:Salary", Amount: -3000.0, Commodity: "EUR"},
-				{Name: "Assets:Bank", Amount: 2500.0, Commodity: "EUR"},
-				{Name: "Expenses:Tax", Amount: 500.0, Commodity: "EUR"},
+				{Name: "Assets:Bank"},
+				{Name: "Assets:Cash"},
+				{Name: "Expenses:Food"},
 			},
 		}
-		if err := e.validateBalance(1); err != nil {
-			t.Errorf("validateBalance() error = %v, want nil", err)
+
+		// Should check all accounts for Expenses/Income, not just first two
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
-}
 
-func TestValidateSubtree(t *testing.T) {
-	t.Run("empty invoices directory with no files referenced", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("entry with four accounts Income in third position", func(t *testing.T) {
+		e := &LedgerEntry{
+			Date:     time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Name:     "Complex income entry",
+			Metadata: map[string]string{},
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bank"},
+				{Name: "Assets:Cash"},
+				{Name: "Income:Salary"},
+				{Name: "Liabilities:Tax"},
+			},
 		}
-		defer os.RemoveAll("invoices")
 
-		seenFiles := make(map[string]bool)
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+		// Should check all accounts for Expenses/Income
+		err := e.procMetadata(false, false, 1, nil, nil)
+		if err != nil {
+			t.Errorf("procMetadata() error = %v, want nil", err)
 		}
 	})
+}
 
-	t.Run("PDF in invoices directory that is referenced", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+func TestValidateBalance(t *testing.T) {
+	t.Run("balanced two-account entry", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.0, Commodity: "EUR"},
+			},
 		}
-		defer os.RemoveAll("invoices")
-
-		file1 := filepath.Join("invoices", "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
+	})
 
-		seenFiles := map[string]bool{file1: true}
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+	t.Run("balanced three-account entry", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 100.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -70.0, Commodity: "EUR"},
+				{Name: "Assets:Cash", Amount: -30.0, Commodity: "EUR"},
+			},
 		}
-		// File should be removed from seenFiles after processing
-		if seenFiles[file1] {
-			t.Error("file should have been removed from seenFiles")
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("PDF in invoices directory not referenced warns but passes", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("unbalanced entry returns error", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -40.0, Commodity: "EUR"},
+			},
 		}
-		defer os.RemoveAll("invoices")
-
-		file1 := filepath.Join("invoices", "unreferenced.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		err := e.validateBalance(5, 0, nil)
+		if err == nil {
+			t.Fatal("validateBalance() expected error, got nil")
 		}
-
-		seenFiles := make(map[string]bool)
-		// Should pass (just logs warning, doesn't error)
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+		if !contains(err.Error(), "not balanced") {
+			t.Errorf("error should mention not balanced, got: %v", err)
+		}
+		if !contains(err.Error(), "line 5") {
+			t.Errorf("error should mention line number, got: %v", err)
+		}
+		if !contains(err.Error(), "EUR") {
+			t.Errorf("error should mention commodity, got: %v", err)
 		}
 	})
 
-	t.Run("non-PDF file in invoices directory is ignored", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("elided amount is calculated", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 80.0, Commodity: "EUR"},
+				{Name: "Expenses:Tips", Amount: 15.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: 0, Commodity: ""}, // elided
+			},
 		}
-		defer os.RemoveAll("invoices")
-
-		// Create a non-PDF file
-		file1 := filepath.Join("invoices", "readme.txt")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
-
-		seenFiles := make(map[string]bool)
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+		if e.Accounts[2].Amount != -95.0 {
+			t.Errorf("elided amount = %v, want -95.0", e.Accounts[2].Amount)
+		}
+		if e.Accounts[2].Commodity != "EUR" {
+			t.Errorf("elided commodity = %v, want EUR", e.Accounts[2].Commodity)
 		}
 	})
 
-	t.Run("file referenced but not in invoices directory", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("multiple elided amounts returns error", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: 0, Commodity: ""},
+				{Name: "Assets:Cash", Amount: 0, Commodity: ""},
+			},
 		}
-		defer os.RemoveAll("invoices")
-
-		seenFiles := map[string]bool{"/some/other/path/invoice.pdf": true}
-		err := validateSubtree(seenFiles)
+		err := e.validateBalance(3, 0, nil)
 		if err == nil {
-			t.Fatal("validateSubtree() expected error for file not in filesystem, got nil")
+			t.Fatal("validateBalance() expected error, got nil")
 		}
-		if !contains(err.Error(), "file referenced in ledger but not found in filesystem") {
-			t.Errorf("error should mention file not found, got: %v", err)
+		if !contains(err.Error(), "multiple accounts with elided amounts") {
+			t.Errorf("error should mention multiple elided amounts, got: %v", err)
 		}
 	})
 
-	t.Run("invoices directory does not exist", func(t *testing.T) {
-		// Make sure invoices directory doesn't exist
-		os.RemoveAll("invoices")
-
-		seenFiles := make(map[string]bool)
-		err := validateSubtree(seenFiles)
+	t.Run("elided amount with no other amounts returns error", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bank", Amount: 0, Commodity: ""},
+			},
+		}
+		err := e.validateBalance(1, 0, nil)
 		if err == nil {
-			t.Fatal("validateSubtree() expected error for missing invoices dir, got nil")
+			t.Fatal("validateBalance() expected error, got nil")
 		}
-		if !contains(err.Error(), "error traversing invoice subtree") {
-			t.Errorf("error should mention traversing error, got: %v", err)
+		if !contains(err.Error(), "cannot infer elided amount without other amounts") {
+			t.Errorf("error should mention cannot infer, got: %v", err)
 		}
 	})
 
-	t.Run("PDF in subdirectory of invoices", func(t *testing.T) {
-		if err := os.MkdirAll(filepath.Join("invoices", "2024"), 0755); err != nil {
-			t.Fatalf("failed to create invoices subdir: %v", err)
+	t.Run("elided amount with multiple commodities allowed", func(t *testing.T) {
+		// Multi-commodity entries with elided amount are allowed (like opening balances)
+		// The elided account implicitly receives balancing amounts for each commodity
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bank", Amount: 100.0, Commodity: "EUR"},
+				{Name: "Assets:USD", Amount: 110.0, Commodity: "USD"},
+				{Name: "Equity:Opening", Amount: 0, Commodity: ""}, // elided
+			},
 		}
-		defer os.RemoveAll("invoices")
-
-		file1 := filepath.Join("invoices", "2024", "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		err := e.validateBalance(1, 0, nil)
+		if err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
+		}
+		// Elided account should remain unchanged (no single commodity can be set)
+		if e.Accounts[2].Commodity != "" {
+			t.Errorf("elided Commodity = %v, want empty", e.Accounts[2].Commodity)
 		}
+	})
 
-		seenFiles := map[string]bool{file1: true}
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+	t.Run("balanced entry with floating-point precision", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 33.33, Commodity: "EUR"},
+				{Name: "Expenses:Tips", Amount: 33.33, Commodity: "EUR"},
+				{Name: "Expenses:Tax", Amount: 33.34, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -100.0, Commodity: "EUR"},
+			},
 		}
-		if seenFiles[file1] {
-			t.Error("file should have been removed from seenFiles")
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("multiple PDFs some referenced some not", func(t *testing.T) {
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("small imbalance within epsilon passes", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.004, Commodity: "EUR"}, // off by 0.004 < 0.005
+			},
 		}
-		defer os.RemoveAll("invoices")
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil (within epsilon)", err)
+		}
+	})
 
-		file1 := filepath.Join("invoices", "referenced.pdf")
-		file2 := filepath.Join("invoices", "unreferenced.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("imbalance exceeding epsilon fails", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.01, Commodity: "EUR"}, // off by 0.01 > 0.005
+			},
 		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		err := e.validateBalance(1, 0, nil)
+		if err == nil {
+			t.Fatal("validateBalance() expected error for imbalance exceeding epsilon")
 		}
+	})
 
-		seenFiles := map[string]bool{file1: true}
-		// Should pass - unreferenced file just logs warning
-		if err := validateSubtree(seenFiles); err != nil {
-			t.Errorf("validateSubtree() error = %v, want nil", err)
+	t.Run("multiple commodities each balanced", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:EUR", Amount: -100.0, Commodity: "EUR"},
+				{Name: "Assets:USD", Amount: 110.0, Commodity: "USD"},
+				{Name: "Expenses:Exchange", Amount: 100.0, Commodity: "EUR"},
+				{Name: "Expenses:Exchange", Amount: -110.0, Commodity: "USD"},
+			},
 		}
-		if seenFiles[file1] {
-			t.Error("referenced file should have been removed from seenFiles")
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
 	})
-}
 
-func TestValidateMetadata(t *testing.T) {
-	t.Run("non-strict mode returns nil", func(t *testing.T) {
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file": "/nonexistent/file.pdf",
-					},
-				},
+	t.Run("multiple commodities no balance required", func(t *testing.T) {
+		// Multi-commodity entries don't require balancing (like currency exchange)
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Cash", Amount: 160.0, Commodity: "USD"},
+				{Name: "Assets:Checkings", Amount: -130.36, Commodity: "EUR"},
 			},
 		}
-		// Non-strict mode should return nil without checking anything
-		if err := l.validateMetadata(false); err != nil {
-			t.Errorf("validateMetadata(false) error = %v, want nil", err)
+		err := e.validateBalance(1, 0, nil)
+		if err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("entries without file metadata are skipped", func(t *testing.T) {
-		// Create invoices directory to satisfy validateSubtree
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("elided amount first in list", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bank", Amount: 0, Commodity: ""}, // elided first
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Expenses:Tips", Amount: 10.0, Commodity: "EUR"},
+			},
 		}
-		defer os.RemoveAll("invoices")
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
+		}
+		if e.Accounts[0].Amount != -60.0 {
+			t.Errorf("elided amount = %v, want -60.0", e.Accounts[0].Amount)
+		}
+		if e.Accounts[0].Commodity != "EUR" {
+			t.Errorf("elided commodity = %v, want EUR", e.Accounts[0].Commodity)
+		}
+	})
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{Metadata: map[string]string{}},
-				{Metadata: map[string]string{"note": "just a note"}},
+	t.Run("negative amounts balance correctly", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Income:Salary", Amount: -3000.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: 2500.0, Commodity: "EUR"},
+				{Name: "Expenses:Tax", Amount: 500.0, Commodity: "EUR"},
 			},
 		}
-		if err := l.validateMetadata(true); err != nil {
-			t.Errorf("validateMetadata() error = %v, want nil", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
 		}
 	})
-
-	t.Run("duplicate flag skips validation", func(t *testing.T) {
-		// Create invoices directory and put file there to satisfy validateSubtree
-		if err := os.MkdirAll("invoices", 0755); err != nil {
-			t.Fatalf("failed to create invoices dir: %v", err)
+	t.Run("imbalance within per-entry tolerance passes", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.01, Commodity: "EUR"}, // off by 0.01 > 0.005
+			},
+			Metadata: map[string]string{"tolerance": "0,02"},
 		}
-		defer os.RemoveAll("invoices")
-
-		file1 := filepath.Join("invoices", "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("test content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil (within tolerance)", err)
 		}
+	})
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":   file1,
-						"sha256": "abc123",
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file":      file1,
-						"sha256":    "abc123",
-						"duplicate": "true",
-					},
-				},
+	t.Run("tolerance never narrows epsilon", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.004, Commodity: "EUR"},
 			},
+			Metadata: map[string]string{"tolerance": "0"},
 		}
-		// Second entry is marked as duplicate, so should not error
-		if err := l.validateMetadata(true); err != nil {
-			t.Errorf("validateMetadata() error = %v, want nil", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Errorf("validateBalance() error = %v, want nil (within epsilon)", err)
 		}
 	})
 
-	t.Run("duplicate file detection", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("imbalance exceeding per-entry tolerance fails", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.05, Commodity: "EUR"},
+			},
+			Metadata: map[string]string{"tolerance": "0.02"},
 		}
+		if err := e.validateBalance(1, 0, nil); err == nil {
+			t.Fatal("validateBalance() expected error for imbalance exceeding tolerance")
+		}
+	})
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":   file1,
-						"sha256": "hash1",
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file":   file1,
-						"sha256": "hash2",
-					},
-				},
+	t.Run("invalid tolerance", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50.0, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50.0, Commodity: "EUR"},
 			},
+			Metadata: map[string]string{"tolerance": "-0.5"},
 		}
-		err := l.validateMetadata(true)
-		if err == nil {
-			t.Fatal("validateMetadata() expected error for duplicate file, got nil")
-		}
-		if !contains(err.Error(), "duplicate file") {
-			t.Errorf("error should mention duplicate file, got: %v", err)
+		err := e.validateBalance(4, 0, nil)
+		if err == nil || !contains(err.Error(), "line 4: invalid tolerance metadata") {
+			t.Errorf("validateBalance() error = %v, want invalid tolerance", err)
 		}
 	})
 
-	t.Run("duplicate hash detection", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("elided amount rounded to remove residue", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				// 0.1 * 3 leaves residue in floating point
+				{Name: "Assets:Bitcoin", Amount: 0.1, Commodity: "BTC",
+					PriceType: "@", PriceAmount: 3, PriceCommodity: "EUR"},
+				{Name: "Assets:Bank"},
+			},
 		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		if err := e.validateBalance(1, 0, nil); err != nil {
+			t.Fatalf("validateBalance() error = %v, want nil", err)
 		}
+		if e.Accounts[1].Amount != -0.3 {
+			t.Errorf("elided amount = %v, want -0.3", e.Accounts[1].Amount)
+		}
+	})
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":   file1,
-						"sha256": "samehash",
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file":   file2,
-						"sha256": "samehash",
-					},
-				},
+	t.Run("elided amount keeps undeclared precision", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bitcoin", Amount: 0.12345678, Commodity: "BTC"},
+				{Name: "Assets:Wallet"},
 			},
 		}
-		err := l.validateMetadata(true)
-		if err == nil {
-			t.Fatal("validateMetadata() expected error for duplicate hash, got nil")
+		if err := e.validateBalance(1, 0, map[string]int{"EUR": 2}); err != nil {
+			t.Fatalf("validateBalance() error = %v, want nil", err)
 		}
-		if !contains(err.Error(), "duplicate hash") {
-			t.Errorf("error should mention duplicate hash, got: %v", err)
+		if e.Accounts[1].Amount != -0.12345678 {
+			t.Errorf("elided amount = %v, want -0.12345678", e.Accounts[1].Amount)
 		}
 	})
 
-	t.Run("fileTwo duplicate file detection", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("elided amount rounded to declared precision", func(t *testing.T) {
+		e := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Assets:Bitcoin", Amount: 0.5, Commodity: "BTC",
+					PriceType: "@", PriceAmount: 302.48, PriceCommodity: "EUR"},
+				{Name: "Assets:Bank"},
+			},
 		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+		if err := e.validateBalance(1, 0, map[string]int{"EUR": 2}); err != nil {
+			t.Fatalf("validateBalance() error = %v, want nil", err)
 		}
-
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":      file1,
-						"sha256":    "hash1",
-						"fileTwo":   file2,
-						"sha256Two": "hash2",
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file":   file2,
-						"sha256": "hash3",
-					},
-				},
-			},
+		if e.Accounts[1].Amount != -151.24 {
+			t.Errorf("elided amount = %v, want -151.24", e.Accounts[1].Amount)
 		}
-		err := l.validateMetadata(true)
-		if err == nil {
-			t.Fatal("validateMetadata() expected error for duplicate fileTwo, got nil")
+	})
+}
+
+func TestValidateSubtree(t *testing.T) {
+	t.Run("empty invoices directory with no files referenced", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
 		}
-		if !contains(err.Error(), "duplicate file") {
-			t.Errorf("error should mention duplicate file, got: %v", err)
+		defer os.RemoveAll("invoices")
+
+		seenFiles := make(map[string]bool)
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("fileTwo duplicate hash detection", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		file3 := filepath.Join(dir, "invoice3.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("PDF in invoices directory that is referenced", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
 		}
-		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+		defer os.RemoveAll("invoices")
+
+		file1 := filepath.Join("invoices", "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
-		if err := os.WriteFile(file3, []byte("content3"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+
+		seenFiles := map[string]bool{file1: true}
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
+		}
+		// File should be removed from seenFiles after processing
+		if seenFiles[file1] {
+			t.Error("file should have been removed from seenFiles")
 		}
+	})
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":      file1,
-						"sha256":    "hash1",
-						"fileTwo":   file2,
-						"sha256Two": "samehash",
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file":   file3,
-						"sha256": "samehash",
-					},
-				},
-			},
+	t.Run("PDF in invoices directory not referenced warns but passes", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
 		}
-		err := l.validateMetadata(true)
-		if err == nil {
-			t.Fatal("validateMetadata() expected error for duplicate hash in fileTwo, got nil")
+		defer os.RemoveAll("invoices")
+
+		file1 := filepath.Join("invoices", "unreferenced.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
 		}
-		if !contains(err.Error(), "duplicate hash") {
-			t.Errorf("error should mention duplicate hash, got: %v", err)
+
+		seenFiles := make(map[string]bool)
+		// Should pass (just logs warning, doesn't error)
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
 		}
 	})
 
-	t.Run("hash calculated when not provided", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		file2 := filepath.Join(dir, "invoice2.pdf")
-		// Same content = same hash
-		if err := os.WriteFile(file1, []byte("identical content"), 0644); err != nil {
-			t.Fatalf("failed to write test file: %v", err)
+	t.Run("non-PDF file in invoices directory is ignored", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
 		}
-		if err := os.WriteFile(file2, []byte("identical content"), 0644); err != nil {
+		defer os.RemoveAll("invoices")
+
+		// Create a non-PDF file
+		file1 := filepath.Join("invoices", "readme.txt")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file": file1,
-						// no sha256 provided - will be calculated
-					},
-				},
-				{
-					Metadata: map[string]string{
-						"file": file2,
-						// no sha256 provided - will be calculated
-					},
-				},
-			},
+		seenFiles := make(map[string]bool)
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
 		}
-		err := l.validateMetadata(true)
+	})
+
+	t.Run("file referenced but not in invoices directory", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
+		}
+		defer os.RemoveAll("invoices")
+
+		seenFiles := map[string]bool{"/some/other/path/invoice.pdf": true}
+		err := validateSubtree(seenFiles, nil)
 		if err == nil {
-			t.Fatal("validateMetadata() expected error for duplicate calculated hash, got nil")
+			t.Fatal("validateSubtree() expected error for file not in filesystem, got nil")
 		}
-		if !contains(err.Error(), "duplicate hash") {
-			t.Errorf("error should mention duplicate hash, got: %v", err)
+		if !contains(err.Error(), "file referenced in ledger but not found in filesystem") {
+			t.Errorf("error should mention file not found, got: %v", err)
 		}
 	})
 
-	t.Run("hash calculation error for missing file", func(t *testing.T) {
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file": "/nonexistent/file.pdf",
-						// no sha256 provided - will try to calculate
-					},
-				},
-			},
-		}
-		err := l.validateMetadata(true)
+	t.Run("invoices directory does not exist", func(t *testing.T) {
+		// Make sure invoices directory doesn't exist
+		os.RemoveAll("invoices")
+
+		seenFiles := make(map[string]bool)
+		err := validateSubtree(seenFiles, nil)
 		if err == nil {
-			t.Fatal("validateMetadata() expected error for missing file, got nil")
+			t.Fatal("validateSubtree() expected error for missing invoices dir, got nil")
 		}
-		if !contains(err.Error(), "failed to calculate SHA256") {
-			t.Errorf("error should mention SHA256 calculation failure, got: %v", err)
+		if !contains(err.Error(), "error traversing invoice subtree") {
+			t.Errorf("error should mention traversing error, got: %v", err)
 		}
 	})
 
-	t.Run("fileTwo hash calculation error", func(t *testing.T) {
-		dir := t.TempDir()
-		file1 := filepath.Join(dir, "invoice1.pdf")
-		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+	t.Run("PDF in subdirectory of invoices", func(t *testing.T) {
+		if err := os.MkdirAll(filepath.Join("invoices", "2024"), 0755); err != nil {
+			t.Fatalf("failed to create invoices subdir: %v", err)
+		}
+		defer os.RemoveAll("invoices")
+
+		file1 := filepath.Join("invoices", "2024", "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("content"), 0644); err != nil {
 			t.Fatalf("failed to write test file: %v", err)
 		}
 
-		l := &Ledger{
-			Entries: []LedgerEntry{
-				{
-					Metadata: map[string]string{
-						"file":    file1,
-						"sha256":  "hash1",
-						"fileTwo": "/nonexistent/file.pdf",
-						// no sha256Two provided - will try to calculate
-					},
-				},
-			},
+		seenFiles := map[string]bool{file1: true}
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
 		}
-		err := l.validateMetadata(true)
+		if seenFiles[file1] {
+			t.Error("file should have been removed from seenFiles")
+		}
+	})
+
+	t.Run("multiple PDFs some referenced some not", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
+		}
+		defer os.RemoveAll("invoices")
+
+		file1 := filepath.Join("invoices", "referenced.pdf")
+		file2 := filepath.Join("invoices", "unreferenced.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		seenFiles := map[string]bool{file1: true}
+		// Should pass - unreferenced file just logs warning
+		if err := validateSubtree(seenFiles, nil); err != nil {
+			t.Errorf("validateSubtree() error = %v, want nil", err)
+		}
+		if seenFiles[file1] {
+			t.Error("referenced file should have been removed from seenFiles")
+		}
+	})
+}
+
+func TestValidateMetadata(t *testing.T) {
+	t.Run("non-strict mode returns nil", func(t *testing.T) {
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file": "/nonexistent/file.pdf",
+					},
+				},
+			},
+		}
+		// Non-strict mode should return nil without checking anything
+		if err := l.validateMetadata(false); err != nil {
+			t.Errorf("validateMetadata(false) error = %v, want nil", err)
+		}
+	})
+
+	t.Run("entries without file metadata are skipped", func(t *testing.T) {
+		// Create invoices directory to satisfy validateSubtree
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
+		}
+		defer os.RemoveAll("invoices")
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{Metadata: map[string]string{}},
+				{Metadata: map[string]string{"note": "just a note"}},
+			},
+		}
+		if err := l.validateMetadata(true); err != nil {
+			t.Errorf("validateMetadata() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("duplicate flag skips validation", func(t *testing.T) {
+		// Create invoices directory and put file there to satisfy validateSubtree
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatalf("failed to create invoices dir: %v", err)
+		}
+		defer os.RemoveAll("invoices")
+
+		file1 := filepath.Join("invoices", "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("test content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":   file1,
+						"sha256": "abc123",
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file":      file1,
+						"sha256":    "abc123",
+						"duplicate": "true",
+					},
+				},
+			},
+		}
+		// Second entry is marked as duplicate, so should not error
+		if err := l.validateMetadata(true); err != nil {
+			t.Errorf("validateMetadata() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("duplicate file detection", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":   file1,
+						"sha256": "hash1",
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file":   file1,
+						"sha256": "hash2",
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for duplicate file, got nil")
+		}
+		if !contains(err.Error(), "duplicate file") {
+			t.Errorf("error should mention duplicate file, got: %v", err)
+		}
+	})
+
+	t.Run("duplicate hash detection", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":   file1,
+						"sha256": "samehash",
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file":   file2,
+						"sha256": "samehash",
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for duplicate hash, got nil")
+		}
+		if !contains(err.Error(), "duplicate hash") {
+			t.Errorf("error should mention duplicate hash, got: %v", err)
+		}
+	})
+
+	t.Run("fileTwo duplicate file detection", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":      file1,
+						"sha256":    "hash1",
+						"fileTwo":   file2,
+						"sha256Two": "hash2",
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file":   file2,
+						"sha256": "hash3",
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for duplicate fileTwo, got nil")
+		}
+		if !contains(err.Error(), "duplicate file") {
+			t.Errorf("error should mention duplicate file, got: %v", err)
+		}
+	})
+
+	t.Run("fileTwo duplicate hash detection", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		file3 := filepath.Join(dir, "invoice3.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("content2"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file3, []byte("content3"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":      file1,
+						"sha256":    "hash1",
+						"fileTwo":   file2,
+						"sha256Two": "samehash",
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file":   file3,
+						"sha256": "samehash",
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for duplicate hash in fileTwo, got nil")
+		}
+		if !contains(err.Error(), "duplicate hash") {
+			t.Errorf("error should mention duplicate hash, got: %v", err)
+		}
+	})
+
+	t.Run("hash calculated when not provided", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		file2 := filepath.Join(dir, "invoice2.pdf")
+		// Same content = same hash
+		if err := os.WriteFile(file1, []byte("identical content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		if err := os.WriteFile(file2, []byte("identical content"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file": file1,
+						// no sha256 provided - will be calculated
+					},
+				},
+				{
+					Metadata: map[string]string{
+						"file": file2,
+						// no sha256 provided - will be calculated
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for duplicate calculated hash, got nil")
+		}
+		if !contains(err.Error(), "duplicate hash") {
+			t.Errorf("error should mention duplicate hash, got: %v", err)
+		}
+	})
+
+	t.Run("hash calculation error for missing file", func(t *testing.T) {
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file": "/nonexistent/file.pdf",
+						// no sha256 provided - will try to calculate
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for missing file, got nil")
+		}
+		if !contains(err.Error(), "failed to calculate SHA256") {
+			t.Errorf("error should mention SHA256 calculation failure, got: %v", err)
+		}
+	})
+
+	t.Run("fileTwo hash calculation error", func(t *testing.T) {
+		dir := t.TempDir()
+		file1 := filepath.Join(dir, "invoice1.pdf")
+		if err := os.WriteFile(file1, []byte("content1"), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{
+					Metadata: map[string]string{
+						"file":    file1,
+						"sha256":  "hash1",
+						"fileTwo": "/nonexistent/file.pdf",
+						// no sha256Two provided - will try to calculate
+					},
+				},
+			},
+		}
+		err := l.validateMetadata(true)
+		if err == nil {
+			t.Fatal("validateMetadata() expected error for missing fileTwo, got nil")
+		}
+		if !contains(err.Error(), "failed to calculate SHA256") {
+			t.Errorf("error should mention SHA256 calculation failure, got: %v", err)
+		}
+	})
+}
+
+func TestStaleDuplicateFlags(t *testing.T) {
+	l := &Ledger{
+		Entries: []LedgerEntry{
+			{
+				LineNumber: 1,
+				Metadata: map[string]string{
+					"file":   "invoices/a.pdf",
+					"sha256": "hashA",
+				},
+			},
+			{
+				LineNumber: 5,
+				Metadata: map[string]string{
+					"file":      "invoices/a.pdf",
+					"sha256":    "hashA",
+					"duplicate": "true",
+				},
+			},
+			{
+				LineNumber: 9,
+				Metadata: map[string]string{
+					"file":      "invoices/b.pdf",
+					"sha256":    "hashB",
+					"duplicate": "true",
+				},
+			},
+			{
+				LineNumber: 13,
+				Metadata: map[string]string{
+					"file":      "invoices/c.pdf",
+					"sha256":    "hashA",
+					"duplicate": "true",
+				},
+			},
+			{
+				LineNumber: 17,
+			},
+		},
+	}
+
+	lines := l.StaleDuplicateFlags()
+	if len(lines) != 1 || lines[0] != 9 {
+		t.Errorf("StaleDuplicateFlags() = %v, want [9]", lines)
+	}
+}
+
+func TestReparse(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	content += `
+2024/01/15 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := l.Reparse(Config{}); err != nil {
+		t.Fatalf("Reparse() error: %v", err)
+	}
+	if len(l.Entries) != 2 {
+		t.Fatalf("Entries len = %d, want 2", len(l.Entries))
+	}
+	if l.Entries[1].LineNumber != 10 {
+		t.Errorf("LineNumber = %d, want 10", l.Entries[1].LineNumber)
+	}
+
+	// a failing reparse leaves the ledger unchanged
+	if err := os.WriteFile(ledgerFile, []byte("invalid\n  Assets:Bank\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := l.Reparse(Config{}); err == nil {
+		t.Fatal("Reparse() expected error for invalid ledger, got nil")
+	}
+	if len(l.Entries) != 2 {
+		t.Errorf("Entries len = %d, want 2", len(l.Entries))
+	}
+}
+
+func TestAppendFrom(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/15 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	t.Run("append entries", func(t *testing.T) {
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		appended := `
+2024/01/20 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank
+`
+		if err := l.AppendFrom(strings.NewReader(appended)); err != nil {
+			t.Fatalf("AppendFrom() error: %v", err)
+		}
+		if len(l.Entries) != 2 {
+			t.Fatalf("Entries len = %d, want 2", len(l.Entries))
+		}
+		if l.Entries[1].Name != "Restaurant" {
+			t.Errorf("Name = %s, want Restaurant", l.Entries[1].Name)
+		}
+		if l.Entries[1].LineNumber != 10 {
+			t.Errorf("LineNumber = %d, want 10", l.Entries[1].LineNumber)
+		}
+	})
+
+	t.Run("entries dated before last entry", func(t *testing.T) {
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		appended := `
+2024/01/20 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank
+
+2024/01/01 Bakery
+  Expenses:Food  5,00 EUR
+  Assets:Bank
+`
+		err = l.AppendFrom(strings.NewReader(appended))
+		if err == nil {
+			t.Fatal("AppendFrom() expected error for out-of-order dates, got nil")
+		}
+		if !contains(err.Error(), "is before") {
+			t.Errorf("error should mention date order, got: %v", err)
+		}
+		if len(l.Entries) != 1 {
+			t.Errorf("Entries len = %d, want 1", len(l.Entries))
+		}
+	})
+
+	t.Run("failure leaves declarations unchanged", func(t *testing.T) {
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		appended := `
+commodity USD
+account Assets:Cash
+D 1.000,00 USD
+
+2024/01/01 Bakery
+  Expenses:Food  5,00 EUR
+  Assets:Bank
+`
+		err = l.AppendFrom(strings.NewReader(appended))
+		if err == nil || !contains(err.Error(), "is before") {
+			t.Fatalf("AppendFrom() error = %v, want out-of-order dates", err)
+		}
+		if l.Commodities["USD"] || l.Accounts["Assets:Cash"] {
+			t.Error("declarations of the failed append should be discarded")
+		}
+		if l.DefaultCommodity != "" {
+			t.Errorf("DefaultCommodity = %q, want empty", l.DefaultCommodity)
+		}
+		if len(l.Entries) != 1 {
+			t.Errorf("Entries len = %d, want 1", len(l.Entries))
+		}
+	})
+
+	t.Run("open apply account", func(t *testing.T) {
+		ledgerFile := filepath.Join(dir, "apply.ledger")
+		content := `apply account Personal
+
+2024/01/15 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		appended := `
+2024/01/20 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank
+
+end apply account
+
+2024/01/21 Bakery
+  Expenses:Food  5,00 EUR
+  Assets:Bank
+`
+		if err := l.AppendFrom(strings.NewReader(appended)); err != nil {
+			t.Fatalf("AppendFrom() error: %v", err)
+		}
+		if len(l.Entries) != 3 {
+			t.Fatalf("Entries len = %d, want 3", len(l.Entries))
+		}
+		if got := l.Entries[1].Accounts[0].Name; got != "Personal:Expenses:Food" {
+			t.Errorf("Name = %s, want Personal:Expenses:Food", got)
+		}
+		if got := l.Entries[2].Accounts[0].Name; got != "Expenses:Food" {
+			t.Errorf("Name = %s, want Expenses:Food", got)
+		}
+	})
+}
+
+func TestDateRange(t *testing.T) {
+	t.Run("empty ledger", func(t *testing.T) {
+		var l Ledger
+		if _, _, ok := l.DateRange(); ok {
+			t.Error("DateRange() ok = true, want false")
+		}
+	})
+
+	t.Run("uses effective dates", func(t *testing.T) {
+		l := &Ledger{
+			Entries: []LedgerEntry{
+				{Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{
+					Date:          time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+					EffectiveDate: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		}
+		first, last, ok := l.DateRange()
+		if !ok {
+			t.Fatal("DateRange() ok = false, want true")
+		}
+		if first.Format(DateFormat) != "2024/01/01" {
+			t.Errorf("first = %s, want 2024/01/01", first.Format(DateFormat))
+		}
+		if last.Format(DateFormat) != "2024/02/01" {
+			t.Errorf("last = %s, want 2024/02/01", last.Format(DateFormat))
+		}
+	})
+}
+
+func TestEntriesAsOf(t *testing.T) {
+	l := &Ledger{
+		Entries: []LedgerEntry{
+			{Name: "first", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "second", Date: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+			{
+				Name:          "third",
+				Date:          time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC),
+				EffectiveDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	entries := l.EntriesAsOf(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+	if len(entries) != 2 || entries[1].Name != "second" {
+		t.Errorf("EntriesAsOf() len = %d, want 2 ending with second", len(entries))
+	}
+	entries = l.EntriesAsOf(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if len(entries) != 2 {
+		t.Errorf("EntriesAsOf() len = %d, want 2 (third is effective in March)", len(entries))
+	}
+	entries = l.EntriesAsOf(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	if len(entries) != 0 {
+		t.Errorf("EntriesAsOf() len = %d, want 0", len(entries))
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount    float64
+		precision int
+		want      string
+	}{
+		{amount: 50, precision: 2, want: "50,00"},
+		{amount: -151.24, precision: 2, want: "-151,24"},
+		{amount: 1.5, precision: 8, want: "1,50000000"},
+		{amount: 0.00000005, precision: 8, want: "0,00000005"},
+		{amount: 42.5, precision: 0, want: "42"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount, tt.precision); got != tt.want {
+			t.Errorf("formatAmount(%v, %d) = %s, want %s", tt.amount, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAmountExact(t *testing.T) {
+	tests := []struct {
+		amount    float64
+		precision int
+		want      string
+	}{
+		{amount: 50, precision: 2, want: "50,00"},
+		{amount: 0.12345678, precision: 2, want: "0,12345678"},
+		{amount: -4938.2712, precision: 2, want: "-4938,2712"},
+		{amount: 42.5, precision: 0, want: "42,5"},
+		{amount: 1.5, precision: 8, want: "1,50000000"},
+	}
+	for _, tt := range tests {
+		if got := formatAmountExact(tt.amount, tt.precision); got != tt.want {
+			t.Errorf("formatAmountExact(%v, %d) = %s, want %s", tt.amount, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	fileA := filepath.Join(dir, "a.ledger")
+	fileB := filepath.Join(dir, "b.ledger")
+
+	contentA := `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/01 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/20 Bakery
+  Expenses:Food  5,00 EUR
+  Assets:Bank
+`
+	contentB := `commodity EUR
+commodity USD
+
+account Assets:Bank
+account Expenses:Food
+
+2024/01/10 Restaurant
+  Expenses:Food  25,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(fileA, []byte(contentA), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(fileB, []byte(contentB), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	t.Run("entries merged in date order", func(t *testing.T) {
+		l, err := New(fileA, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		other, err := New(fileB, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if err := l.Merge(other); err != nil {
+			t.Fatalf("Merge() error: %v", err)
+		}
+		if len(l.Entries) != 3 {
+			t.Fatalf("Entries len = %d, want 3", len(l.Entries))
+		}
+		for i, name := range []string{"Grocery store", "Restaurant", "Bakery"} {
+			if l.Entries[i].Name != name {
+				t.Errorf("Entries[%d].Name = %s, want %s", i, l.Entries[i].Name, name)
+			}
+		}
+		if !l.Commodities["USD"] {
+			t.Error("Commodities should contain USD")
+		}
+	})
+
+	t.Run("strict mode inconsistent declarations", func(t *testing.T) {
+		l := &Ledger{
+			Filename:    "a.ledger",
+			Commodities: map[string]bool{"EUR": true},
+			Accounts:    map[string]bool{"Assets:Bank": true},
+			config:      Config{Strict: true},
+		}
+		other := &Ledger{
+			Filename:    "b.ledger",
+			Commodities: map[string]bool{"EUR": true, "USD": true},
+			Accounts:    map[string]bool{"Assets:Bank": true},
+		}
+		err := l.Merge(other)
+		if err == nil {
+			t.Fatal("Merge() expected error for inconsistent declarations, got nil")
+		}
+		if !contains(err.Error(), "commodity USD declared in b.ledger but not in a.ledger") {
+			t.Errorf("error should name the conflicting declaration, got: %v", err)
+		}
+	})
+}
+
+func TestValidateBalanceWarnFraction(t *testing.T) {
+	// captureStderr returns what fn writes to stderr
+	captureStderr := func(fn func()) string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		stderr := os.Stderr
+		os.Stderr = w
+		fn()
+		os.Stderr = stderr
+		w.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read pipe: %v", err)
+		}
+		return string(b)
+	}
+
+	e := &LedgerEntry{
+		Accounts: []LedgerAccount{
+			{Name: "Expenses:Fees", Amount: 0.10, Commodity: "EUR"},
+			{Name: "Assets:Bank", Amount: -0.104, Commodity: "EUR"},
+		},
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		var err error
+		out := captureStderr(func() { err = e.validateBalance(7, 0, nil) })
+		if err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
+		}
+		if out != "" {
+			t.Errorf("validateBalance() warned: %s", out)
+		}
+	})
+
+	t.Run("warns for large relative imbalance", func(t *testing.T) {
+		var err error
+		out := captureStderr(func() { err = e.validateBalance(7, 0.01, nil) })
+		if err != nil {
+			t.Errorf("validateBalance() error = %v, want nil", err)
+		}
+		if !contains(out, "line 7: entry balanced within epsilon") {
+			t.Errorf("validateBalance() warning = %q, want relative imbalance warning", out)
+		}
+	})
+
+	t.Run("small relative imbalance passes", func(t *testing.T) {
+		large := &LedgerEntry{
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Rent", Amount: 1000.00, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -1000.004, Commodity: "EUR"},
+			},
+		}
+		out := captureStderr(func() { large.validateBalance(7, 0.01, nil) })
+		if out != "" {
+			t.Errorf("validateBalance() warned: %s", out)
+		}
+	})
+}
+
+func TestValidateTransfers(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []LedgerAccount
+		metadata map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "exchange between commodities",
+			accounts: []LedgerAccount{
+				{Name: "Assets:EUR", Amount: -100, Commodity: "EUR"},
+				{Name: "Assets:USD", Amount: 110, Commodity: "USD"},
+			},
+		},
+		{
+			name: "balanced transfer with fee",
+			accounts: []LedgerAccount{
+				{Name: "Assets:Exchange", Amount: -1, Commodity: "BTC"},
+				{Name: "Assets:Cold", Amount: 1, Commodity: "BTC"},
+				{Name: "Expenses:Fees", Amount: 2, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -2, Commodity: "EUR"},
+			},
+		},
+		{
+			name: "unbalanced transfer",
+			accounts: []LedgerAccount{
+				{Name: "Assets:Exchange", Amount: -1, Commodity: "BTC"},
+				{Name: "Assets:Cold", Amount: 0.9, Commodity: "BTC"},
+				{Name: "Expenses:Fees", Amount: 2, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -2, Commodity: "EUR"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unbalanced transfer marked as exchange",
+			accounts: []LedgerAccount{
+				{Name: "Assets:Exchange", Amount: -1, Commodity: "BTC"},
+				{Name: "Assets:Cold", Amount: 0.9, Commodity: "BTC"},
+				{Name: "Assets:Bank", Amount: 100, Commodity: "EUR"},
+			},
+			metadata: map[string]string{"exchange": "true"},
+		},
+		{
+			name: "one-sided commodity marked as transfer",
+			accounts: []LedgerAccount{
+				{Name: "Assets:EUR", Amount: -100, Commodity: "EUR"},
+				{Name: "Assets:USD", Amount: 110, Commodity: "USD"},
+			},
+			metadata: map[string]string{"transfer": "true"},
+			wantErr:  true,
+		},
+		{
+			name: "elided account balances all commodities",
+			accounts: []LedgerAccount{
+				{Name: "Assets:Exchange", Amount: -1, Commodity: "BTC"},
+				{Name: "Assets:Cold", Amount: 0.9, Commodity: "BTC"},
+				{Name: "Assets:Bank", Amount: 100, Commodity: "EUR"},
+				{Name: "Equity:Adjustments", Elided: true},
+			},
+		},
+		{
+			name: "exchange with price annotation and fee",
+			accounts: []LedgerAccount{
+				{Name: "Assets:USD", Amount: 110, Commodity: "USD",
+					PriceType: "@@", PriceAmount: 100, PriceCommodity: "EUR"},
+				{Name: "Expenses:Fees", Amount: 1, Commodity: "EUR"},
+				{Name: "Assets:EUR", Amount: -101, Commodity: "EUR"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &LedgerEntry{Accounts: tt.accounts, Metadata: tt.metadata}
+			err := e.validateTransfers(3)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("validateTransfers() expected error, got nil")
+				}
+				if !contains(err.Error(), "line 3: transfer not balanced") {
+					t.Errorf("error should mention unbalanced transfer, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("validateTransfers() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestDisplayAmount(t *testing.T) {
+	tests := []struct {
+		account LedgerAccount
+		want    float64
+	}{
+		{account: LedgerAccount{Name: "Income:Salary", Amount: -3000}, want: 3000},
+		{account: LedgerAccount{Name: "Liabilities:CreditCard", Amount: -200}, want: 200},
+		{account: LedgerAccount{Name: "Expenses:Food", Amount: 50}, want: 50},
+		{account: LedgerAccount{Name: "Assets:Bank", Amount: -50}, want: -50},
+		{account: LedgerAccount{Name: "Incomes", Amount: -1}, want: -1},
+	}
+	for _, tt := range tests {
+		if got := tt.account.DisplayAmount(); got != tt.want {
+			t.Errorf("DisplayAmount() for %s = %v, want %v", tt.account.Name, got, tt.want)
+		}
+	}
+}
+
+func TestParseAccountCommodityAliases(t *testing.T) {
+	commodities := map[string]bool{"BTC": true, "EUR": true}
+	accounts := map[string]bool{"Assets:Kraken": true}
+	aliases := map[string]string{"XBT": "BTC", "ZEUR": "EUR"}
+
+	a, err := parseAccount("Assets:Kraken  0,5 XBT @ 40000,00 ZEUR", 1, true,
+		commodities, accounts, aliases, "")
+	if err != nil {
+		t.Fatalf("parseAccount() unexpected error: %v", err)
+	}
+	if a.Commodity != "BTC" {
+		t.Errorf("parseAccount() Commodity = %v, want BTC", a.Commodity)
+	}
+	if a.PriceCommodity != "EUR" {
+		t.Errorf("parseAccount() PriceCommodity = %v, want EUR", a.PriceCommodity)
+	}
+
+	// without the alias the undeclared symbol is rejected in strict mode
+	_, err = parseAccount("Assets:Kraken  0,5 XBT", 1, true, commodities, accounts, nil, "")
+	if err == nil || !contains(err.Error(), "commodity unknown: XBT") {
+		t.Errorf("parseAccount() error = %v, want unknown commodity", err)
+	}
+}
+
+// errWriter fails every write.
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestWriteTo(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `; Personal ledger
+; owner: test
+
+commodity BTC
+commodity EUR
+
+account Assets:Bank
+account Assets:Wallet
+account Expenses:Food
+
+tag invoice
+
+2024/01/05=2024/01/06 Store | weekly groceries
+  Expenses:Food                                   50,00 EUR
+  Assets:Bank
+    ; invoice: 2024-001
+
+2024/01/10 Exchange
+  Assets:Wallet                                   0,01 BTC @ 40000,00 EUR
+  Assets:Bank                                     -400,00 EUR
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := l.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+	if buf.String() != content {
+		t.Errorf("WriteTo() =\n%s\nwant:\n%s", buf.String(), content)
+	}
+
+	// parsing the output yields an equivalent ledger
+	roundTrip := filepath.Join(dir, "roundtrip.ledger")
+	if err := os.WriteFile(roundTrip, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l2, err := New(roundTrip, false, false, "")
+	if err != nil {
+		t.Fatalf("New() round trip error: %v", err)
+	}
+	var buf2 bytes.Buffer
+	if _, err := l2.WriteTo(&buf2); err != nil {
+		t.Fatalf("WriteTo() round trip error: %v", err)
+	}
+	if buf2.String() != buf.String() {
+		t.Errorf("round trip differs:\n%s\nwant:\n%s", buf2.String(), buf.String())
+	}
+
+	t.Run("write error", func(t *testing.T) {
+		if _, err := l.WriteTo(errWriter{}); err != io.ErrClosedPipe {
+			t.Errorf("WriteTo() error = %v, want %v", err, io.ErrClosedPipe)
+		}
+	})
+
+	t.Run("round trip keeps all digits", func(t *testing.T) {
+		content := `2024/01/10 Exchange
+  Assets:BTC                                      0,12345678 BTC @ 40000,00 EUR
+  Assets:Bank
+
+2024/01/11 Fee
+  Expenses:Fees                                   0,00001 BTC
+  Assets:BTC                                      -0,00001 BTC = 0,12344678 BTC
+`
+		ledgerFile := filepath.Join(dir, "precision.ledger")
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := l.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error: %v", err)
+		}
+		if buf.String() != content {
+			t.Errorf("WriteTo() =\n%s\nwant:\n%s", buf.String(), content)
+		}
+
+		roundTrip := filepath.Join(dir, "precision-roundtrip.ledger")
+		if err := os.WriteFile(roundTrip, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		l2, err := New(roundTrip, false, false, "")
+		if err != nil {
+			t.Fatalf("New() round trip error: %v", err)
+		}
+		if !reflect.DeepEqual(l2.Entries, l.Entries) {
+			t.Errorf("round trip Entries =\n%+v\nwant:\n%+v", l2.Entries, l.Entries)
+		}
+	})
+}
+
+func TestNewWindowsFile(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := "\xef\xbb\xbf; exported\r\n" +
+		"\r\n" +
+		"commodity EUR\r\n" +
+		"\r\n" +
+		"account Assets:Bank\r\n" +
+		"account Expenses:Food\r\n" +
+		"\r\n" +
+		"2024/01/05 Store\r\n" +
+		"  Expenses:Food  50,00 EUR\r\n" +
+		"  Assets:Bank\r\n" +
+		"\r\n" +
+		"2024/01/06 Store\r\n" +
+		"  Expenses:Food  10,00 EUR\r\n" +
+		"  Assets:Bank  -10,00 EUR\r\n"
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if len(l.HeaderComments) != 1 || l.HeaderComments[0] != "; exported" {
+		t.Errorf("HeaderComments = %q, want [\"; exported\"]", l.HeaderComments)
+	}
+	if !l.Commodities["EUR"] {
+		t.Error("commodity EUR not parsed")
+	}
+	if !l.Accounts["Assets:Bank"] || !l.Accounts["Expenses:Food"] {
+		t.Errorf("Accounts = %v", l.Accounts)
+	}
+	if len(l.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(l.Entries))
+	}
+	if got := l.Entries[1].Accounts[1].Commodity; got != "EUR" {
+		t.Errorf("Commodity = %q, want EUR", got)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("entries and declarations", func(t *testing.T) {
+		main := write("main.ledger", `commodity EUR
+
+account Assets:Bank
+account Expenses:Food
+
+include years/2023.ledger
+
+2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`)
+		write("years/2023.ledger", `; 2023
+account Expenses:Rent
+
+2023/12/01 Landlord
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+`)
+		l, err := New(main, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if len(l.Entries) != 2 {
+			t.Fatalf("got %d entries, want 2", len(l.Entries))
+		}
+		if l.Entries[0].Name != "Landlord" || l.Entries[0].LineNumber != 4 {
+			t.Errorf("first entry = %s at line %d, want Landlord at line 4",
+				l.Entries[0].Name, l.Entries[0].LineNumber)
+		}
+		if l.Entries[1].LineNumber != 8 {
+			t.Errorf("second entry at line %d, want 8", l.Entries[1].LineNumber)
+		}
+		if !l.Accounts["Expenses:Rent"] {
+			t.Error("account declared in included file missing")
+		}
+	})
+
+	t.Run("error in included file", func(t *testing.T) {
+		main := write("bad.ledger", "include sub/bad.ledger\n")
+		write("sub/bad.ledger", `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank  -40,00 EUR
+`)
+		_, err := New(main, false, false, "")
+		if err == nil {
+			t.Fatal("New() expected error, got nil")
+		}
+		want := filepath.Join(dir, "sub", "bad.ledger") + ": ledger: line 1: entry not balanced"
+		if !contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	})
+
+	t.Run("entries before included entries", func(t *testing.T) {
+		main := write("order.ledger", `2024/02/01 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+include order-2024.ledger
+`)
+		write("order-2024.ledger", `2024/01/01 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+`)
+		if _, err := New(main, false, false, ""); err == nil {
+			t.Error("New() expected error for entries out of order, got nil")
+		}
+	})
+
+	t.Run("circular include", func(t *testing.T) {
+		a := write("a.ledger", "include b.ledger\n")
+		b := write("b.ledger", "include a.ledger\n")
+		_, err := New(a, false, false, "")
+		if err == nil {
+			t.Fatal("New() expected error, got nil")
+		}
+		if !contains(err.Error(), "circular include: "+b+" includes "+a) {
+			t.Errorf("error = %v, want circular include of both files", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		main := write("missing.ledger", "include nothere.ledger\n")
+		_, err := New(main, false, false, "")
+		if err == nil || !contains(err.Error(), "line 1:") {
+			t.Errorf("New() error = %v, want error for line 1", err)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/20=2024/02/01 Exchange
+  Assets:Wallet  0,01 BTC @ 40000,00 EUR
+  Assets:Bank  -400,00 EUR
+
+2024/02/10 Landlord
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	date := func(s string) time.Time {
+		d, err := time.Parse(DateFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name string
+		opts FilterOptions
+		want []string
+	}{
+		{
+			name: "all",
+			want: []string{"Store", "Exchange", "Landlord"},
+		},
+		{
+			name: "account prefix",
+			opts: FilterOptions{AccountPrefix: "Expenses:"},
+			want: []string{"Store", "Landlord"},
+		},
+		{
+			name: "effective date in range",
+			opts: FilterOptions{StartDate: date("2024/02/01"), EndDate: date("2024/02/10")},
+			want: []string{"Exchange"},
+		},
+		{
+			name: "start only",
+			opts: FilterOptions{StartDate: date("2024/02/02")},
+			want: []string{"Landlord"},
+		},
+		{
+			name: "commodity",
+			opts: FilterOptions{Commodity: "BTC"},
+			want: []string{"Exchange"},
+		},
+		{
+			name: "all conditions",
+			opts: FilterOptions{AccountPrefix: "Assets:Bank", Commodity: "EUR",
+				EndDate: date("2024/02/01")},
+			want: []string{"Store"},
+		},
+		{
+			name: "no match",
+			opts: FilterOptions{AccountPrefix: "Income:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range l.Filter(tt.opts) {
+				got = append(got, e.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAccountLotDate(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		wantDate    string
+		wantPrice   string
+		errContains string
+	}{
+		{
+			name:     "lot date",
+			line:     "Assets:Bitcoin  1 BTC [2023/12/01]",
+			wantDate: "2023/12/01",
+		},
+		{
+			name:      "lot date with price",
+			line:      "Assets:Bitcoin  1 BTC [2023/12/01] @ 42000 USD",
+			wantDate:  "2023/12/01",
+			wantPrice: "USD",
+		},
+		{
+			name:        "invalid lot date",
+			line:        "Assets:Bitcoin  1 BTC [2023/13/01]",
+			errContains: "line 1: invalid lot date",
+		},
+		{
+			name:        "unknown token",
+			line:        "Assets:Bitcoin  1 BTC 2023/12/01",
+			errContains: "invalid account format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := parseAccount(tt.line, 1, false, nil, nil, nil, "")
+			if tt.errContains != "" {
+				if err == nil || !contains(err.Error(), tt.errContains) {
+					t.Errorf("parseAccount() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAccount() unexpected error: %v", err)
+			}
+			if got := a.LotDate.Format(DateFormat); got != tt.wantDate {
+				t.Errorf("parseAccount() LotDate = %s, want %s", got, tt.wantDate)
+			}
+			if a.Commodity != "BTC" || a.PriceCommodity != tt.wantPrice {
+				t.Errorf("parseAccount() = %+v", a)
+			}
+
+			// the lot date is printed after the commodity
+			var buf bytes.Buffer
+			a.write(&countWriter{w: &buf}, func(amount float64, _ string) string {
+				return formatAmount(amount, 0)
+			})
+			if !contains(buf.String(), "1 BTC ["+tt.wantDate+"]") {
+				t.Errorf("write() = %q, want lot date after commodity", buf.String())
+			}
+		})
+	}
+}
+
+func TestStrictEffectiveDates(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `2024/01/15=2024/01/20 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/02/15=2024/02/01 Landlord
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		if _, err := NewWithConfig(ledgerFile, Config{}); err != nil {
+			t.Errorf("NewWithConfig() error = %v, want nil", err)
+		}
+	})
+
+	t.Run("reversed dates", func(t *testing.T) {
+		_, err := NewWithConfig(ledgerFile, Config{StrictEffectiveDates: true})
 		if err == nil {
-			t.Fatal("validateMetadata() expected error for missing fileTwo, got nil")
+			t.Fatal("NewWithConfig() expected error, got nil")
 		}
-		if !contains(err.Error(), "failed to calculate SHA256") {
-			t.Errorf("error should mention SHA256 calculation failure, got: %v", err)
+		if !contains(err.Error(), "line 5: effective date 2024/02/01 is before 2024/02/15") {
+			t.Errorf("error = %v, want reversed effective date on line 5", err)
+		}
+	})
+}
+
+func TestEntriesMissingAttachments(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+	noMetadataFile := filepath.Join(dir, "no-metadata.conf")
+
+	content := `2024/01/05 Store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/06 Transfer
+  Assets:Savings  100,00 EUR
+  Assets:Bank
+
+2024/01/07 Bank
+  Expenses:Fees  2,00 EUR
+  Assets:Bank
+
+2024/01/31 Employer
+  Assets:Bank  3000,00 EUR
+  Income:Salary
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(noMetadataFile, []byte("Expenses:Fees\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, noMetadataFile)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	missing := l.EntriesMissingAttachments()
+	if len(missing) != 2 {
+		t.Fatalf("EntriesMissingAttachments() returned %d entries, want 2: %+v", len(missing), missing)
+	}
+	if m := missing[0]; m.LineNumber != 1 || m.Payee != "Store" ||
+		m.Date.Format(DateFormat) != "2024/01/05" ||
+		strings.Join(m.Accounts, ",") != "Expenses:Food,Assets:Bank" {
+		t.Errorf("missing[0] = %+v", m)
+	}
+	if m := missing[1]; m.LineNumber != 13 || m.Payee != "Employer" {
+		t.Errorf("missing[1] = %+v", m)
+	}
+}
+
+func TestEntryID(t *testing.T) {
+	date := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	newEntry := func() *LedgerEntry {
+		return &LedgerEntry{
+			Date: date,
+			Name: "Store",
+			Accounts: []LedgerAccount{
+				{Name: "Expenses:Food", Amount: 50, Commodity: "EUR"},
+				{Name: "Assets:Bank", Amount: -50, Commodity: "EUR", Elided: true},
+			},
+		}
+	}
+	id := newEntry().ID()
+	if len(id) != 64 {
+		t.Fatalf("ID() = %q, want hex encoded SHA256 hash", id)
+	}
+
+	same := []struct {
+		name   string
+		modify func(e *LedgerEntry)
+	}{
+		{"line numbers", func(e *LedgerEntry) { e.LineNumber, e.EndLineNumber = 10, 12 }},
+		{"metadata", func(e *LedgerEntry) { e.Metadata = map[string]string{"file": "a.pdf"} }},
+		{"posting order", func(e *LedgerEntry) {
+			e.Accounts[0], e.Accounts[1] = e.Accounts[1], e.Accounts[0]
+		}},
+	}
+	for _, tt := range same {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEntry()
+			tt.modify(e)
+			if got := e.ID(); got != id {
+				t.Errorf("ID() changed to %s", got)
+			}
+		})
+	}
+
+	different := []struct {
+		name   string
+		modify func(e *LedgerEntry)
+	}{
+		{"date", func(e *LedgerEntry) { e.Date = date.AddDate(0, 0, 1) }},
+		{"effective date", func(e *LedgerEntry) { e.EffectiveDate = date.AddDate(0, 0, 1) }},
+		{"payee", func(e *LedgerEntry) { e.Name = "Market" }},
+		{"amount", func(e *LedgerEntry) {
+			e.Accounts[0].Amount = 51
+			e.Accounts[1].Amount = -51
+		}},
+		{"account", func(e *LedgerEntry) { e.Accounts[0].Name = "Expenses:Drinks" }},
+	}
+	for _, tt := range different {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEntry()
+			tt.modify(e)
+			if got := e.ID(); got == id {
+				t.Error("ID() did not change")
+			}
+		})
+	}
+}
+
+func TestDefaultCommodity(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		t.Helper()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		return ledgerFile
+	}
+
+	t.Run("postings without commodity", func(t *testing.T) {
+		ledgerFile := write(`commodity EUR
+
+D 1.000,00 EUR
+
+2024/01/05 Store
+  Expenses:Food  50,00
+  Assets:Cash  -10,00 EUR
+  Assets:Bank
+`)
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if l.DefaultCommodity != "EUR" {
+			t.Errorf("DefaultCommodity = %q, want EUR", l.DefaultCommodity)
+		}
+		accounts := l.Entries[0].Accounts
+		if accounts[0].Commodity != "EUR" || accounts[0].Amount != 50 {
+			t.Errorf("posting = %v %v, want 50 EUR", accounts[0].Amount, accounts[0].Commodity)
+		}
+		// a bare account name still balances the rest
+		if !accounts[2].Elided || accounts[2].Amount != -40 {
+			t.Errorf("elided posting = %+v, want -40 EUR", accounts[2])
+		}
+
+		// the directive survives printing
+		var buf bytes.Buffer
+		if _, err := l.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error: %v", err)
+		}
+		if !contains(buf.String(), "\nD 1000,00 EUR\n") {
+			t.Errorf("WriteTo() lost the default commodity:\n%s", buf.String())
+		}
+	})
+
+	t.Run("no default commodity", func(t *testing.T) {
+		ledgerFile := write(`2024/01/05 Store
+  Expenses:Food  50,00
+  Assets:Bank
+`)
+		_, err := New(ledgerFile, false, false, "")
+		if err == nil || !contains(err.Error(), "invalid account format") {
+			t.Errorf("New() error = %v, want invalid account format", err)
+		}
+	})
+
+	t.Run("invalid directive", func(t *testing.T) {
+		ledgerFile := write("D EUR\n")
+		_, err := New(ledgerFile, false, false, "")
+		if err == nil || !contains(err.Error(), "line 1: invalid default commodity format") {
+			t.Errorf("New() error = %v, want invalid default commodity format", err)
+		}
+	})
+
+	t.Run("undeclared in strict mode", func(t *testing.T) {
+		if err := os.MkdirAll("invoices", 0755); err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll("invoices")
+		ledgerFile := write(`commodity EUR
+
+D 1.000,00 USD
+`)
+		_, err := New(ledgerFile, true, false, "")
+		if err == nil || !contains(err.Error(), "line 3: default commodity unknown: USD") {
+			t.Errorf("New() error = %v, want unknown default commodity", err)
+		}
+	})
+}
+
+func TestCommodityFormat(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		t.Helper()
+		ledgerFile := filepath.Join(dir, "test.ledger")
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		return ledgerFile
+	}
+
+	t.Run("precision per commodity", func(t *testing.T) {
+		content := `commodity BTC
+  format 1,00000000 BTC
+commodity EUR
+commodity JPY
+	format 1000 JPY
+
+2024/01/10 Exchange
+  Assets:Wallet                                   0,01000000 BTC @ 40000,00 EUR
+  Assets:Bank                                     -400,00 EUR
+
+2024/01/11 Exchange
+  Assets:Cash                                     1500 JPY @@ 10,00 EUR
+  Assets:Bank                                     -10,00 EUR
+`
+		l, err := New(write(content), false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if l.CommodityPrecision["BTC"] != 8 || l.CommodityPrecision["JPY"] != 0 {
+			t.Errorf("CommodityPrecision = %v, want BTC: 8, JPY: 0", l.CommodityPrecision)
+		}
+		if _, ok := l.CommodityPrecision["EUR"]; ok {
+			t.Error("EUR has no format")
+		}
+		if !l.Commodities["JPY"] {
+			t.Error("commodity JPY not declared")
+		}
+
+		var buf bytes.Buffer
+		if _, err := l.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error: %v", err)
+		}
+		want := strings.Replace(content, "\tformat 1000 JPY", "  format 1 JPY", 1)
+		if buf.String() != want {
+			t.Errorf("WriteTo() =\n%s\nwant:\n%s", buf.String(), want)
+		}
+	})
+
+	t.Run("format after entries", func(t *testing.T) {
+		content := `commodity EUR
+
+2024/01/10 Exchange
+  Assets:Wallet  0,01 BTC @ 40000,00 EUR
+  Assets:Bank  -400,00 EUR
+
+commodity BTC
+  format 1,00000000 BTC
+`
+		l, err := New(write(content), false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		if l.CommodityPrecision["BTC"] != 8 {
+			t.Errorf("CommodityPrecision = %v, want BTC: 8", l.CommodityPrecision)
+		}
+		if !l.Commodities["BTC"] {
+			t.Error("commodity BTC not declared")
+		}
+	})
+
+	errTests := []struct {
+		name        string
+		content     string
+		errContains string
+	}{
+		{
+			name:        "unknown subdirective",
+			content:     "commodity BTC\n  note Bitcoin\n",
+			errContains: "line 2: unknown commodity subdirective: note Bitcoin",
+		},
+		{
+			name:        "other commodity",
+			content:     "commodity BTC\n  format 1,00 EUR\n",
+			errContains: "line 2: invalid commodity format",
+		},
+		{
+			name:        "invalid amount",
+			content:     "commodity BTC\n  format 1e-8 BTC\n",
+			errContains: "line 2: invalid commodity format amount: 1e-8",
+		},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(write(tt.content), false, false, "")
+			if err == nil || !contains(err.Error(), tt.errContains) {
+				t.Errorf("New() error = %v, want error containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestStrictContents(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+
+account Assets:Bank
+account Expenses:Office
+
+2024/01/15 Stationery
+  Expenses:Office  50,00 EUR
+  Assets:Bank
+  ; file: invoices/a.pdf
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if _, err := os.Stat("invoices"); !os.IsNotExist(err) {
+		t.Fatalf("invoices directory must not exist for this test")
+	}
+
+	cfg := Config{
+		Strict:   true,
+		Contents: map[string][]byte{"invoices/a.pdf": []byte("invoice")},
+	}
+	l, err := NewWithConfig(ledgerFile, cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() error = %v, want nil", err)
+	}
+	if len(l.Entries) != 1 {
+		t.Errorf("Entries len = %d, want 1", len(l.Entries))
+	}
+
+	cfg.Contents = map[string][]byte{"invoices/b.pdf": []byte("invoice")}
+	_, err = NewWithConfig(ledgerFile, cfg)
+	if err == nil {
+		t.Fatal("NewWithConfig() expected error for missing file, got nil")
+	}
+}
+
+func TestConfigPrecision(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+	content := `2024/01/10 Store
+  Expenses:Food  42,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	zero, negative := 0, -1
+	tests := []struct {
+		name      string
+		precision *int
+		want      string
+	}{
+		{name: "unset", precision: nil, want: "42,00 EUR"},
+		{name: "zero", precision: &zero, want: "42 EUR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewWithConfig(ledgerFile, Config{Precision: tt.precision})
+			if err != nil {
+				t.Fatalf("NewWithConfig() error: %v", err)
+			}
+			var buf bytes.Buffer
+			if _, err := l.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo() error: %v", err)
+			}
+			if !contains(buf.String(), " "+tt.want+"\n") {
+				t.Errorf("WriteTo() =\n%s\nwant amount %s", buf.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("negative", func(t *testing.T) {
+		_, err := NewWithConfig(ledgerFile, Config{Precision: &negative})
+		if err == nil || !contains(err.Error(), "negative precision: -1") {
+			t.Errorf("NewWithConfig() error = %v, want negative precision", err)
 		}
 	})
 }
 
+func TestMergeDeferMetadata(t *testing.T) {
+	if err := os.MkdirAll("invoices", 0755); err != nil {
+		t.Fatalf("failed to create invoices dir: %v", err)
+	}
+	defer os.RemoveAll("invoices")
+	invoice := filepath.Join("invoices", "a.pdf")
+	if err := os.WriteFile(invoice, []byte("invoice"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	dir := t.TempDir()
+	declarations := `commodity EUR
+
+account Assets:Bank
+account Expenses:Office
+
+`
+	file2023 := filepath.Join(dir, "2023.ledger")
+	file2024 := filepath.Join(dir, "2024.ledger")
+	if err := os.WriteFile(file2023, []byte(declarations+`2023/12/15 Stationery
+  Expenses:Office  50,00 EUR
+  Assets:Bank
+  ; file: invoices/a.pdf
+`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.WriteFile(file2024, []byte(declarations+`2024/01/15 Stationery
+  Expenses:Office  50,00 EUR
+  Assets:Bank
+  ; file: invoices/a.pdf
+`), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	// each file is valid on its own
+	for _, filename := range []string{file2023, file2024} {
+		if _, err := NewWithConfig(filename, Config{Strict: true}); err != nil {
+			t.Fatalf("NewWithConfig(%s) error: %v", filename, err)
+		}
+	}
+
+	cfg := Config{Strict: true, DeferMetadata: true}
+	l, err := NewWithConfig(file2023, cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() error: %v", err)
+	}
+	other, err := NewWithConfig(file2024, cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() error: %v", err)
+	}
+	if err := l.Merge(other); err != nil {
+		t.Fatalf("Merge() error: %v", err)
+	}
+	err = l.ValidateMetadata()
+	if err == nil || !contains(err.Error(), "duplicate file: invoices/a.pdf") {
+		t.Errorf("ValidateMetadata() error = %v, want duplicate file", err)
+	}
+}
+
 // contains checks if s contains substr
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsAt(s, substr))
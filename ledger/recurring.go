@@ -0,0 +1,77 @@
+package ledger
+
+import (
+	"sort"
+	"time"
+)
+
+// Recurrence is the interval in which a recurring entry repeats.
+type Recurrence int
+
+// Recurrences of recurring entries.
+const (
+	Monthly Recurrence = iota
+	Weekly
+)
+
+// RecurringRule is a template for an entry that repeats in a fixed interval,
+// like rent on the first of each month.
+type RecurringRule struct {
+	Payee      string
+	Postings   []LedgerAccount
+	Recurrence Recurrence
+	Start      time.Time // date of the first entry
+	End        time.Time // entries are generated for dates before End
+}
+
+// recurringMetadataKey marks entries generated by ExpandRecurring.
+const recurringMetadataKey = "recurring"
+
+// addMonths adds n months to date. If the day does not exist in the target
+// month, the last day of that month is used instead of overflowing into the
+// next one.
+func addMonths(date time.Time, n int) time.Time {
+	first := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	first = first.AddDate(0, n, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	day := date.Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, date.Location())
+}
+
+// ExpandRecurring returns the entries generated by rules, sorted by date.
+// Every rule yields an entry per interval from its start date until its end
+// date. Generated entries are not part of the ledger, they have no line
+// number and carry the metadata "recurring: true" to tell them apart from
+// parsed entries.
+func (l *Ledger) ExpandRecurring(rules []RecurringRule) []LedgerEntry {
+	var entries []LedgerEntry
+	for _, rule := range rules {
+		for i := 0; ; i++ {
+			var date time.Time
+			switch rule.Recurrence {
+			case Weekly:
+				date = rule.Start.AddDate(0, 0, 7*i)
+			default:
+				date = addMonths(rule.Start, i)
+			}
+			if !date.Before(rule.End) {
+				break
+			}
+			accounts := make([]LedgerAccount, len(rule.Postings))
+			copy(accounts, rule.Postings)
+			entries = append(entries, LedgerEntry{
+				Date:     date,
+				Name:     rule.Payee,
+				Accounts: accounts,
+				Metadata: map[string]string{recurringMetadataKey: "true"},
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+	return entries
+}
@@ -0,0 +1,69 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandRecurring(t *testing.T) {
+	l := &Ledger{}
+	date := func(s string) time.Time {
+		d, err := time.Parse(DateFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	rules := []RecurringRule{
+		{
+			Payee: "Landlord",
+			Postings: []LedgerAccount{
+				{Name: "Expenses:Rent", Amount: 1000, Commodity: "EUR"},
+				{Name: "Assets:Bank"},
+			},
+			Recurrence: Monthly,
+			Start:      date("2024/01/31"),
+			End:        date("2024/04/01"),
+		},
+		{
+			Payee:      "Gym",
+			Postings:   []LedgerAccount{{Name: "Expenses:Sport", Amount: 10, Commodity: "EUR"}},
+			Recurrence: Weekly,
+			Start:      date("2024/02/01"),
+			End:        date("2024/02/15"),
+		},
+	}
+	entries := l.ExpandRecurring(rules)
+
+	want := []struct {
+		date  string
+		payee string
+	}{
+		{"2024/01/31", "Landlord"},
+		{"2024/02/01", "Gym"},
+		{"2024/02/08", "Gym"},
+		{"2024/02/29", "Landlord"},
+		{"2024/03/31", "Landlord"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ExpandRecurring() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		if got := e.Date.Format(DateFormat); got != w.date || e.Name != w.payee {
+			t.Errorf("entry %d = %s %s, want %s %s", i, got, e.Name, w.date, w.payee)
+		}
+		if e.Metadata["recurring"] != "true" {
+			t.Errorf("entry %d not marked as recurring", i)
+		}
+		if e.LineNumber != 0 {
+			t.Errorf("entry %d has line number %d", i, e.LineNumber)
+		}
+	}
+
+	// modifying a generated entry must not change the rule
+	entries[0].Accounts[0].Amount = 0
+	if rules[0].Postings[0].Amount != 1000 {
+		t.Error("generated entries share postings with the rule")
+	}
+}
@@ -0,0 +1,219 @@
+package ledger
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// baseAmount returns the amount of the posting in the base commodity.
+// Postings in another commodity are converted with their price annotation,
+// there is no price database to convert other postings.
+func (a *LedgerAccount) baseAmount(base string) (float64, error) {
+	if a.Commodity == base {
+		return a.Amount, nil
+	}
+	if a.PriceType != "" && a.PriceCommodity == base {
+		amount, _ := a.balanceAmount()
+		return amount, nil
+	}
+	return 0, fmt.Errorf("cannot convert %s to %s", a.Commodity, base)
+}
+
+// basePostings calls fn for every posting of e to an account starting with
+// accountPrefix with its amount converted to base (see baseAmount). An
+// elided account balancing several commodities is resolved as in postings,
+// every commodity it receives a nonzero amount in must be base then.
+func (e *LedgerEntry) basePostings(
+	accountPrefix string,
+	base string,
+	fn func(account string, amount float64),
+) error {
+	for i := range e.Accounts {
+		a := &e.Accounts[i]
+		if !strings.HasPrefix(a.Name, accountPrefix) {
+			continue
+		}
+		if a.Commodity == "" {
+			amounts := e.balancingAmounts()
+			for _, commodity := range sortedCommodities(amounts) {
+				if math.Abs(amounts[commodity]) <= balanceEpsilon {
+					// e.g. a commodity transferred in kind
+					continue
+				}
+				if commodity != base {
+					return fmt.Errorf("ledger: line %d: %s: cannot convert %s to %s",
+						e.LineNumber, a.Name, commodity, base)
+				}
+				fn(a.Name, amounts[commodity])
+			}
+			continue
+		}
+		amount, err := a.baseAmount(base)
+		if err != nil {
+			return fmt.Errorf("ledger: line %d: %s: %s", e.LineNumber, a.Name, err)
+		}
+		fn(a.Name, amount)
+	}
+	return nil
+}
+
+// months returns the number of months between start and end. Partial
+// months are counted as the fraction of their days.
+func months(start, end time.Time) float64 {
+	n := (end.Year()-start.Year())*12 + int(end.Month()) - int(start.Month())
+	// move the start into the month of end and count the remaining days
+	anchor := start.AddDate(0, n, 0)
+	for n > 0 && anchor.After(end) {
+		n--
+		anchor = start.AddDate(0, n, 0)
+	}
+	days := end.Sub(anchor).Hours() / 24
+	daysInMonth := anchor.AddDate(0, 1, 0).Sub(anchor).Hours() / 24
+	return float64(n) + days/daysInMonth
+}
+
+// FiscalYear returns the date range [start, end) of the fiscal year
+// starting in the given calendar year, as configured in
+// Config.FiscalYearStartMonth and Config.FiscalYearStartDay. The range can be
+// passed to the reports taking a start and end date.
+func (l *Ledger) FiscalYear(year int) (start, end time.Time) {
+	month := l.config.FiscalYearStartMonth
+	if month == 0 {
+		month = time.January
+	}
+	day := l.config.FiscalYearStartDay
+	if day == 0 {
+		day = 1
+	}
+	start = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(1, 0, 0)
+}
+
+// MonthlyAverages returns the average monthly amount per account for all
+// accounts starting with accountPrefix in entries dated in [start, end).
+// The sums are divided by the number of months between start and end and
+// amounts are converted to baseCurrency with their price annotations.
+// Elided amounts are resolved as in the balance report.
+func (l *Ledger) MonthlyAverages(
+	accountPrefix string,
+	start, end time.Time,
+	baseCurrency string,
+) (map[string]float64, error) {
+	n := months(start, end)
+	if n <= 0 {
+		return nil, fmt.Errorf("ledger: end %s is not after start %s",
+			end.Format(DateFormat), start.Format(DateFormat))
+	}
+	averages := make(map[string]float64)
+	for _, e := range l.reportEntries() {
+		date := e.currentDate()
+		if date.Before(start) || !date.Before(end) {
+			continue
+		}
+		err := e.basePostings(accountPrefix, baseCurrency, func(account string, amount float64) {
+			averages[account] += amount
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for account := range averages {
+		averages[account] /= n
+	}
+	return averages, nil
+}
+
+// ExpenseRunRate returns the annualized spending of the lookbackMonths
+// months up to and including asOf: the monthly average of all Expenses:
+// postings in that window, converted to baseCurrency, times twelve. It is
+// an error if the window contains no expenses.
+func (l *Ledger) ExpenseRunRate(
+	asOf time.Time,
+	lookbackMonths int,
+	baseCurrency string,
+) (float64, error) {
+	if lookbackMonths <= 0 {
+		return 0, fmt.Errorf("ledger: invalid lookback of %d months", lookbackMonths)
+	}
+	end := asOf.AddDate(0, 0, 1)
+	start := end.AddDate(0, -lookbackMonths, 0)
+	averages, err := l.MonthlyAverages("Expenses:", start, end, baseCurrency)
+	if err != nil {
+		return 0, err
+	}
+	if len(averages) == 0 {
+		return 0, fmt.Errorf("ledger: no expenses between %s and %s",
+			start.Format(DateFormat), asOf.Format(DateFormat))
+	}
+	var monthly float64
+	for _, average := range averages {
+		monthly += average
+	}
+	return monthly * 12, nil
+}
+
+// PayeeTotal is the summed amount of all postings of a payee.
+type PayeeTotal struct {
+	Payee  string
+	Amount float64
+}
+
+// ByPayee sums the postings to accounts starting with accountPrefix in
+// entries dated in [start, end) grouped by the payee of the entry. Amounts
+// are converted to baseCurrency with their price annotations, elided
+// amounts are resolved as in the balance report. The totals are sorted by
+// descending amount, ties are sorted by payee.
+func (l *Ledger) ByPayee(
+	accountPrefix string,
+	start, end time.Time,
+	baseCurrency string,
+) ([]PayeeTotal, error) {
+	sums := make(map[string]float64)
+	for _, e := range l.reportEntries() {
+		date := e.currentDate()
+		if date.Before(start) || !date.Before(end) {
+			continue
+		}
+		err := e.basePostings(accountPrefix, baseCurrency, func(_ string, amount float64) {
+			sums[e.Name] += amount
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	totals := make([]PayeeTotal, 0, len(sums))
+	for payee, amount := range sums {
+		totals = append(totals, PayeeTotal{Payee: payee, Amount: amount})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Amount != totals[j].Amount {
+			return totals[i].Amount > totals[j].Amount
+		}
+		return totals[i].Payee < totals[j].Payee
+	})
+	return totals, nil
+}
+
+// AccountChange returns the net change per commodity of the balance of
+// account, including its subaccounts, in entries dated in [start, end).
+// Elided amounts are resolved as in the balance report.
+func (l *Ledger) AccountChange(account string, start, end time.Time) map[string]float64 {
+	change := make(map[string]float64)
+	entries := l.reportEntries()
+	for i := range entries {
+		e := &entries[i]
+		date := e.currentDate()
+		if date.Before(start) || !date.Before(end) {
+			continue
+		}
+		e.postings(func(name, commodity string, amount float64) {
+			if name == account || strings.HasPrefix(name, account+":") {
+				change[commodity] += amount
+			}
+		})
+	}
+	return change
+}
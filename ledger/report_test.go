@@ -0,0 +1,373 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMonths(t *testing.T) {
+	tests := []struct {
+		start, end time.Time
+		want       float64
+	}{
+		{
+			start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			want:  3,
+		},
+		{
+			start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  12,
+		},
+		{
+			start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC),
+			want:  1.5,
+		},
+		{
+			start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		if got := months(tt.start, tt.end); got != tt.want {
+			t.Errorf("months(%s, %s) = %v, want %v", tt.start.Format(DateFormat),
+				tt.end.Format(DateFormat), got, tt.want)
+		}
+	}
+}
+
+func TestFiscalYear(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		start, end string
+	}{
+		{
+			name:  "calendar year by default",
+			start: "2024/01/01",
+			end:   "2025/01/01",
+		},
+		{
+			name:  "UK tax year",
+			cfg:   Config{FiscalYearStartMonth: time.April, FiscalYearStartDay: 6},
+			start: "2024/04/06",
+			end:   "2025/04/06",
+		},
+		{
+			name:  "month only",
+			cfg:   Config{FiscalYearStartMonth: time.July},
+			start: "2024/07/01",
+			end:   "2025/07/01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Ledger{config: tt.cfg}
+			start, end := l.FiscalYear(2024)
+			if got := start.Format(DateFormat); got != tt.start {
+				t.Errorf("FiscalYear() start = %s, want %s", got, tt.start)
+			}
+			if got := end.Format(DateFormat); got != tt.end {
+				t.Errorf("FiscalYear() end = %s, want %s", got, tt.end)
+			}
+		})
+	}
+}
+
+func TestMonthlyAverages(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+commodity USD
+
+account Assets:Bank
+account Expenses:Food
+account Expenses:Rent
+
+2024/01/01 Rent
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+
+2024/01/15 Grocery store
+  Expenses:Food  100,00 EUR
+  Assets:Bank
+
+2024/02/01 Rent
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+
+2024/02/10 Restaurant abroad
+  Expenses:Food  55,00 USD @ 0,90 EUR
+  Assets:Bank
+
+2024/03/01 Rent
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("averages per account", func(t *testing.T) {
+		averages, err := l.MonthlyAverages("Expenses:", start, end, "EUR")
+		if err != nil {
+			t.Fatalf("MonthlyAverages() error: %v", err)
+		}
+		if averages["Expenses:Rent"] != 900 {
+			t.Errorf("Expenses:Rent = %v, want 900", averages["Expenses:Rent"])
+		}
+		if averages["Expenses:Food"] != 74.75 {
+			t.Errorf("Expenses:Food = %v, want 74.75", averages["Expenses:Food"])
+		}
+		if _, ok := averages["Assets:Bank"]; ok {
+			t.Error("Assets:Bank should not be included")
+		}
+	})
+
+	t.Run("unconvertible commodity", func(t *testing.T) {
+		_, err := l.MonthlyAverages("Expenses:", start, end, "USD")
+		if err == nil {
+			t.Fatal("MonthlyAverages() expected error for missing conversion, got nil")
+		}
+		if !contains(err.Error(), "cannot convert EUR to USD") {
+			t.Errorf("error should mention the conversion, got: %v", err)
+		}
+	})
+
+	t.Run("empty window", func(t *testing.T) {
+		_, err := l.MonthlyAverages("Expenses:", end, start, "EUR")
+		if err == nil {
+			t.Fatal("MonthlyAverages() expected error for empty window, got nil")
+		}
+	})
+
+	t.Run("elided account balancing several commodities", func(t *testing.T) {
+		ledgerFile := filepath.Join(dir, "transfer.ledger")
+		content := `2024/01/20 Transfer
+  Assets:Exchange  -1,00 BTC
+  Assets:Wallet  1,00 BTC
+  Assets:Bank  -2,00 EUR
+  Expenses:Fees
+`
+		if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+		l, err := New(ledgerFile, false, false, "")
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+		averages, err := l.MonthlyAverages("Expenses:", start, end, "EUR")
+		if err != nil {
+			t.Fatalf("MonthlyAverages() error: %v", err)
+		}
+		if averages["Expenses:Fees"] != 1 {
+			t.Errorf("Expenses:Fees = %v, want 1", averages["Expenses:Fees"])
+		}
+	})
+}
+
+func TestExpenseRunRate(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `2023/12/01 Rent
+  Expenses:Rent  800,00 EUR
+  Assets:Bank
+
+2024/01/01 Rent
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+
+2024/02/10 Restaurant abroad
+  Expenses:Food  100,00 USD @ 0,90 EUR
+  Assets:Bank
+
+2024/02/29 Rent
+  Expenses:Rent  900,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	asOf := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+
+	t.Run("annualized", func(t *testing.T) {
+		// (900 + 90 + 900) / 2 months * 12, the entry on asOf is included
+		rate, err := l.ExpenseRunRate(asOf, 2, "EUR")
+		if err != nil {
+			t.Fatalf("ExpenseRunRate() error: %v", err)
+		}
+		if rate != 11340 {
+			t.Errorf("ExpenseRunRate() = %v, want 11340", rate)
+		}
+	})
+
+	t.Run("no expenses", func(t *testing.T) {
+		_, err := l.ExpenseRunRate(time.Date(2023, 11, 30, 0, 0, 0, 0, time.UTC), 1, "EUR")
+		if err == nil || !contains(err.Error(), "no expenses") {
+			t.Errorf("ExpenseRunRate() error = %v, want no expenses", err)
+		}
+	})
+
+	t.Run("invalid lookback", func(t *testing.T) {
+		if _, err := l.ExpenseRunRate(asOf, 0, "EUR"); err == nil {
+			t.Error("ExpenseRunRate() expected error for zero lookback, got nil")
+		}
+	})
+}
+
+func TestByPayee(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+commodity USD
+
+account Assets:Bank
+account Expenses:Food
+account Income:Salary
+
+2024/01/05 Store | weekly groceries
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/01/10 Bakery
+  Expenses:Food  20,00 EUR
+  Assets:Bank
+
+2024/01/12 Store | weekly groceries
+  Expenses:Food  40,00 EUR
+  Assets:Bank
+
+2024/01/20 Diner
+  Expenses:Food  25,00 USD @@ 20,00 EUR
+  Assets:Bank
+
+2024/01/25 Exchange
+  Assets:Exchange  -1,00 BTC
+  Assets:Wallet  1,00 BTC
+  Assets:Bank  -2,00 EUR
+  Expenses:Fees
+
+2024/01/31 Employer
+  Assets:Bank  3000,00 EUR
+  Income:Salary
+
+2024/02/02 Store
+  Expenses:Food  60,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	totals, err := l.ByPayee("Expenses:",
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "EUR")
+	if err != nil {
+		t.Fatalf("ByPayee() error: %v", err)
+	}
+	want := []PayeeTotal{
+		{Payee: "Store", Amount: 90},
+		{Payee: "Bakery", Amount: 20},
+		{Payee: "Diner", Amount: 20},
+		{Payee: "Exchange", Amount: 2},
+	}
+	if len(totals) != len(want) {
+		t.Fatalf("ByPayee() = %v, want %v", totals, want)
+	}
+	for i := range want {
+		if totals[i] != want[i] {
+			t.Errorf("ByPayee()[%d] = %v, want %v", i, totals[i], want[i])
+		}
+	}
+}
+
+func TestAccountChange(t *testing.T) {
+	dir := t.TempDir()
+	ledgerFile := filepath.Join(dir, "test.ledger")
+
+	content := `commodity EUR
+commodity USD
+
+account Assets:Bank
+account Assets:Bank:Savings
+account Assets:Bankrupt
+account Equity:Opening
+account Expenses:Food
+
+2024/01/01 Opening
+  Assets:Bank  1000,00 EUR
+  Assets:Bank  200,00 USD
+  Assets:Bankrupt  1,00 EUR
+  Equity:Opening
+
+2024/01/10 Grocery store
+  Expenses:Food  50,00 EUR
+  Assets:Bank
+
+2024/02/01 Savings
+  Assets:Bank:Savings  300,00 EUR
+  Assets:Bank  -300,00 EUR
+
+2024/04/01 Restaurant
+  Expenses:Food  20,00 EUR
+  Assets:Bank
+`
+	if err := os.WriteFile(ledgerFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	l, err := New(ledgerFile, false, false, "")
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	q1Start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q1End := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("includes subaccounts and elided amounts", func(t *testing.T) {
+		change := l.AccountChange("Assets:Bank", q1Start, q1End)
+		if change["EUR"] != 950 || change["USD"] != 200 {
+			t.Errorf("AccountChange() = %v, want 950 EUR and 200 USD", change)
+		}
+	})
+
+	t.Run("elided multi-commodity account", func(t *testing.T) {
+		change := l.AccountChange("Equity:Opening", q1Start, q1End)
+		if change["EUR"] != -1001 || change["USD"] != -200 {
+			t.Errorf("AccountChange() = %v, want -1001 EUR and -200 USD", change)
+		}
+	})
+
+	t.Run("date range", func(t *testing.T) {
+		change := l.AccountChange("Assets:Bank:Savings", q1End, q1End.AddDate(0, 3, 0))
+		if len(change) != 0 {
+			t.Errorf("AccountChange() = %v, want no change", change)
+		}
+		change = l.AccountChange("Expenses:Food", q1End, q1End.AddDate(0, 3, 0))
+		if change["EUR"] != 20 {
+			t.Errorf("AccountChange() = %v, want 20 EUR", change)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package ledger
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// FileResult is the result of parsing a single ledger file.
+type FileResult struct {
+	Filename string
+	Err      error // nil if the file parsed successfully
+}
+
+// ValidateDir parses every *.ledger file below dir with cfg and returns the
+// result per file in lexical order. A failing file does not stop the
+// validation of the others, the returned error is only set if dir could not
+// be walked.
+func ValidateDir(dir string, cfg Config) ([]FileResult, error) {
+	var results []FileResult
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".ledger") {
+			return nil
+		}
+		_, err = NewWithConfig(path, cfg)
+		results = append(results, FileResult{Filename: path, Err: err})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,71 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDir(t *testing.T) {
+	dir := t.TempDir()
+	valid := `commodity EUR
+
+account Assets:Bank
+account Income:Salary
+
+2024/01/31 Employer
+  Assets:Bank  3000,00 EUR
+  Income:Salary
+`
+	invalid := `2024/01/31 Employer
+  Assets:Bank  3000,00 EUR
+  Income:Salary  -2000,00 EUR
+`
+	files := map[string]string{
+		"a.ledger":          valid,
+		"client/b.ledger":   invalid,
+		"client/c.ledger":   valid,
+		"client/notes.txt":  invalid,
+		"other/d.ledger.gz": invalid,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+
+	results, err := ValidateDir(dir, Config{})
+	if err != nil {
+		t.Fatalf("ValidateDir() error: %v", err)
+	}
+	want := []struct {
+		name string
+		ok   bool
+	}{
+		{"a.ledger", true},
+		{"client/b.ledger", false},
+		{"client/c.ledger", true},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("ValidateDir() returned %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Filename != filepath.Join(dir, w.name) {
+			t.Errorf("result %d: filename = %s, want %s", i, r.Filename, w.name)
+		}
+		if (r.Err == nil) != w.ok {
+			t.Errorf("result %d: %s: error = %v", i, w.name, r.Err)
+		}
+	}
+
+	t.Run("missing dir", func(t *testing.T) {
+		if _, err := ValidateDir(filepath.Join(dir, "missing"), Config{}); err == nil {
+			t.Error("expected error for missing directory")
+		}
+	})
+}
@@ -2,11 +2,15 @@
 package file
 
 import (
+  "bytes"
+  "compress/gzip"
   "crypto/sha256"
   "encoding/hex"
+  "fmt"
   "hash"
   "io"
   "os"
+  "strings"
 )
 
 // Exists checks if filename exists already.
@@ -36,3 +40,34 @@ func hashSum(hash hash.Hash, filename string) (string, error) {
 func SHA256Sum(filename string) (string, error) {
   return hashSum(sha256.New(), filename)
 }
+
+// SHA256Bytes returns the hex encoded SHA256 hash of content.
+func SHA256Bytes(content []byte) string {
+  h := sha256.Sum256(content)
+  return hex.EncodeToString(h[:])
+}
+
+// gzipMagic are the first bytes of a gzip compressed file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Read reads the content of filename. If filename has a .gz extension or
+// starts with the gzip magic bytes, the decompressed content is returned.
+func Read(filename string) ([]byte, error) {
+  b, err := os.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  if !strings.HasSuffix(filename, ".gz") && !bytes.HasPrefix(b, gzipMagic) {
+    return b, nil
+  }
+  r, err := gzip.NewReader(bytes.NewReader(b))
+  if err != nil {
+    return nil, fmt.Errorf("file: %s: %s", filename, err)
+  }
+  defer r.Close()
+  b, err = io.ReadAll(r)
+  if err != nil {
+    return nil, fmt.Errorf("file: %s: %s", filename, err)
+  }
+  return b, nil
+}